@@ -16,8 +16,13 @@ import (
 	"github.com/krateoplatformops/kube-bridge/pkg/handlers"
 	"github.com/krateoplatformops/kube-bridge/pkg/handlers/modules"
 	"github.com/krateoplatformops/kube-bridge/pkg/handlers/secrets"
+	"github.com/krateoplatformops/kube-bridge/pkg/history"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
 	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/operations"
+	"github.com/krateoplatformops/kube-bridge/pkg/policy"
 	"github.com/krateoplatformops/kube-bridge/pkg/support"
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
 	"github.com/rs/zerolog"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -33,6 +38,11 @@ const (
 ┃┏┓┓ ┃┗┛┃ ┃┗┛┃ ┃┃━┫   ┃┗┛┃ ┃┃  ┃┃ ┃┗┛┃ ┃┗┛┃ ┃┃━┫ 
 ┗┛┗┛ ┗━━┛ ┗━━┛ ┗━━┛   ┗━━┛ ┗┛  ┗┛ ┗━━┛ ┗━┓┃ ┗━━┛ ver: VERSION
 Kubernetes Bridge Component            ┗━━┛      cid: BUILD`
+
+	writeTimeout = 30 * time.Second
+	flushTimeout = 5 * time.Second
+	// policySyncTimeout bounds the wait for the policy ConfigMap on startup
+	policySyncTimeout = 30 * time.Second
 )
 
 var (
@@ -46,6 +56,24 @@ func main() {
 	loggerUri := flag.String("logger-uri", support.EnvString("LOG_URI", ""), "logger service uri")
 	debug := flag.Bool("debug", support.EnvBool("KUBE_BRIDGE_DEBUG", true), "dump verbose output")
 	servicePort := flag.Int("port", support.EnvInt("KUBE_BRIDGE_PORT", 8171), "port to listen on")
+	readyTimeout := flag.Duration("ready-timeout", support.EnvDuration("KUBE_BRIDGE_READY_TIMEOUT", 10*time.Minute), "max time to wait for an installed claim to become ready (0 to skip)")
+	maxWait := flag.Duration("max-wait", support.EnvDuration("KUBE_BRIDGE_MAX_WAIT", 15*time.Minute), "max time a synchronous /template request can wait")
+	kubeQPS := flag.Int("kube-qps", support.EnvInt("KUBE_BRIDGE_KUBE_QPS", 50), "max queries per second to the kubernetes api server")
+	kubeBurst := flag.Int("kube-burst", support.EnvInt("KUBE_BRIDGE_KUBE_BURST", 100), "max burst of queries to the kubernetes api server")
+	retention := flag.Duration("operations-retention", support.EnvDuration("KUBE_BRIDGE_OPERATIONS_RETENTION", time.Hour), "how long finished operations are kept to answer retried /template requests")
+	operationsStore := flag.String("operations-store", support.EnvString("KUBE_BRIDGE_OPERATIONS_STORE", operations.StoreMemory), "where the operations are persisted: memory, file:<directory> or configmap:<namespace>")
+	maxBodySize := flag.Int64("max-body-size", int64(support.EnvInt("KUBE_BRIDGE_MAX_BODY_SIZE", 1048576)), "max size in bytes of the request bodies")
+	allowedPackages := flag.String("allowed-packages", support.EnvString("KUBE_BRIDGE_ALLOWED_PACKAGES", strings.Join(policy.DefaultPackages, ",")), "comma separated list of the allowed package GroupKinds")
+	allowedClaimGroups := flag.String("allowed-claim-groups", support.EnvString("KUBE_BRIDGE_ALLOWED_CLAIM_GROUPS", strings.Join(policy.DefaultClaimGroups, ",")), "comma separated list of the allowed claim apiGroup patterns")
+	allowedSupporting := flag.String("allowed-supporting-kinds", support.EnvString("KUBE_BRIDGE_ALLOWED_SUPPORTING_KINDS", strings.Join(policy.DefaultSupporting, ",")), "comma separated list of the GroupKinds allowed along with the claim (i.e. Secret, ProviderConfig.helm.crossplane.io)")
+	numWorkers := flag.Int("workers", support.EnvInt("KUBE_BRIDGE_WORKERS", 10), "max number of module operations running at the same time")
+	queueSize := flag.Int("queue-size", support.EnvInt("KUBE_BRIDGE_QUEUE_SIZE", 100), "max number of module operations waiting for a free worker or for the operations in progress on the same module")
+	notificationWorkers := flag.Int("notification-workers", support.EnvInt("KUBE_BRIDGE_NOTIFICATION_WORKERS", 5), "max number of notifications sent at the same time to the logger service")
+	notificationQueue := flag.Int("notification-queue", support.EnvInt("KUBE_BRIDGE_NOTIFICATION_QUEUE", 1000), "max number of notifications waiting to be sent (the others are dropped)")
+	gracePeriod := flag.Duration("shutdown-grace-period", support.EnvDuration("KUBE_BRIDGE_SHUTDOWN_GRACE_PERIOD", 20*time.Second), "max time to wait on shutdown for the running module operations (the remaining ones are marked as interrupted)")
+	historyNamespace := flag.String("history-namespace", support.EnvString("KUBE_BRIDGE_HISTORY_NAMESPACE", kubernetes.KrateoSystemNamespace), "namespace of the Secrets holding the module revisions")
+	historyLimit := flag.Int("history-limit", support.EnvInt("KUBE_BRIDGE_HISTORY_LIMIT", history.DefaultLimit), "max number of revisions kept per module (0 disables the history)")
+	policyConfigMap := flag.String("policy-configmap", support.EnvString("KUBE_BRIDGE_POLICY_CONFIGMAP", ""), "namespace/name of the ConfigMap overriding the allowed packages, claim groups and supporting kinds")
 
 	flag.Usage = func() {
 		printBanner()
@@ -80,6 +108,8 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("building kube config")
 	}
+	cfg.QPS = float32(*kubeQPS)
+	cfg.Burst = *kubeBurst
 
 	if log.Debug().Enabled() {
 		log.Debug().
@@ -87,16 +117,123 @@ func main() {
 			Str("debug", fmt.Sprintf("%t", *debug)).
 			Str("loggerServiceUrl", *loggerUri).
 			Str("port", fmt.Sprintf("%d", *servicePort)).
+			Str("maxWait", maxWait.String()).
+			Str("readyTimeout", readyTimeout.String()).
+			Str("kubeQPS", fmt.Sprintf("%d", *kubeQPS)).
+			Str("kubeBurst", fmt.Sprintf("%d", *kubeBurst)).
+			Str("operationsRetention", retention.String()).
+			Str("operationsStore", *operationsStore).
+			Str("maxBodySize", fmt.Sprintf("%d", *maxBodySize)).
+			Str("allowedPackages", *allowedPackages).
+			Str("allowedClaimGroups", *allowedClaimGroups).
+			Str("allowedSupportingKinds", *allowedSupporting).
+			Str("policyConfigMap", *policyConfigMap).
+			Str("workers", fmt.Sprintf("%d", *numWorkers)).
+			Str("queueSize", fmt.Sprintf("%d", *queueSize)).
+			Str("notificationWorkers", fmt.Sprintf("%d", *notificationWorkers)).
+			Str("notificationQueue", fmt.Sprintf("%d", *notificationQueue)).
+			Str("shutdownGracePeriod", gracePeriod.String()).
+			Str("historyNamespace", *historyNamespace).
+			Str("historyLimit", fmt.Sprintf("%d", *historyLimit)).
 			Str("@cluster", cfg.Host).
 			Msg("configuration values")
 	}
 
+	// Shared watch on CRDs for the module operations waiting for new kinds
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	crds, err := kubernetes.NewCrdsWatcher(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("creating CRDs watcher")
+	}
+	crds.Start(stopCh)
+
+	// Kubernetes clients shared by all the handlers
+	kf, err := kubernetes.NewFactory(cfg, crds)
+	if err != nil {
+		log.Fatal().Err(err).Msg("creating kubernetes clients")
+	}
+
+	// Bounded pools for the module operations and the outgoing notifications
+	opWorkers := workers.New(*numWorkers, *queueSize)
+	notifyWorkers := workers.New(*notificationWorkers, *notificationQueue)
+
 	// Internal event bus for sending notifications
 	bus := eventbus.New()
 	eid := bus.Subscribe(support.NotificationEventID,
-		support.NotificationDispatcher(*loggerUri))
+		support.NotificationDispatcher(*loggerUri, notifyWorkers))
 	defer bus.Unsubscribe(eid)
 
+	// Registry of the module operations running in background
+	store, err := operations.NewStore(*operationsStore, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("creating operations store")
+	}
+
+	reg, interrupted, err := operations.Open(store, *retention, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("opening operations registry")
+	}
+	if len(interrupted) > 0 {
+		log.Warn().Strs("operations", interrupted).Msg("operations interrupted by the previous process")
+	}
+	rid := bus.Subscribe(support.NotificationEventID, reg.Record)
+	defer bus.Unsubscribe(rid)
+
+	// Allow-list of the packages and claims the module handlers accept
+	rules, err := policy.NewRules(policy.SplitList(*allowedPackages), policy.SplitList(*allowedClaimGroups), policy.SplitList(*allowedSupporting))
+	if err != nil {
+		log.Fatal().Err(err).Msg("building policy")
+	}
+
+	pol := policy.Static(rules)
+	if len(*policyConfigMap) > 0 {
+		ns, name, ok := strings.Cut(*policyConfigMap, "/")
+		if !ok {
+			log.Fatal().Msgf("invalid policy configmap: %s (expected namespace/name)", *policyConfigMap)
+		}
+
+		pw, err := policy.NewWatcher(cfg, ns, name, rules, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("creating policy watcher")
+		}
+		pw.Start(stopCh)
+
+		// the stricter rules of the ConfigMap are enforced from the first request
+		ctx, cancel := context.WithTimeout(context.Background(), policySyncTimeout)
+		err = pw.WaitForSync(ctx)
+		cancel()
+		if err != nil {
+			log.Fatal().Err(err).Msg("loading policy")
+		}
+		pol = pw
+	}
+
+	// Bounded history of the specs applied to each module
+	var hist history.Store
+	if *historyLimit > 0 {
+		hist, err = history.NewSecretStore(cfg, *historyNamespace, *historyLimit)
+		if err != nil {
+			log.Fatal().Err(err).Msg("creating module history")
+		}
+	}
+
+	// Options shared by the module handlers
+	opts := modules.Options{
+		Clients:      kf,
+		Bus:          bus,
+		Registry:     reg,
+		Locks:        operations.NewLocks(),
+		Crds:         crds,
+		Workers:      opWorkers,
+		Policy:       pol,
+		MaxWait:      *maxWait,
+		ReadyTimeout: *readyTimeout,
+		History:      hist,
+		MaxBodySize:  *maxBodySize,
+	}
+
 	// Server Mux
 	mux := mux.NewRouter()
 
@@ -106,6 +243,20 @@ func main() {
 		handlers.HealtHandler(&healthy, Version),
 	))
 
+	// Status endpoint
+	//
+	// Methods:
+	//
+	// GET /status  ' Active workers and queue depth of the operations and notifications pools
+	mux.Handle("/status", middlewares.CorrelationID(
+		middlewares.Timeout(writeTimeout)(
+			handlers.StatusHandler(map[string]workers.Pool{
+				"operations":    opWorkers,
+				"notifications": notifyWorkers,
+			}),
+		),
+	)).Methods(http.MethodGet)
+
 	// Secrets endpoint
 	//
 	// All secrets created by `kube-bridge` have the
@@ -119,39 +270,176 @@ func main() {
 	//                                  ' Payload: {"value": "xxxx"}
 	mux.Handle("/secrets/{namespace}/{name}", middlewares.Logger(log)(
 		middlewares.CorrelationID(
-			secrets.Create(cfg),
+			middlewares.Timeout(writeTimeout)(
+				secrets.Create(kf, *maxBodySize),
+			),
 		),
 	)).Methods(http.MethodPost)
 
 	mux.Handle("/secrets/{namespace}/{name}", middlewares.Logger(log)(
 		middlewares.CorrelationID(
-			secrets.GetOne(cfg),
+			middlewares.Timeout(writeTimeout)(
+				secrets.GetOne(kf),
+			),
 		),
 	)).Methods(http.MethodGet)
 
 	mux.Handle("/secrets/{namespace}/{name}", middlewares.Logger(log)(
 		middlewares.CorrelationID(
-			secrets.DeleteOne(cfg),
+			middlewares.Timeout(writeTimeout)(
+				secrets.DeleteOne(kf),
+			),
 		),
 	)).Methods(http.MethodDelete)
 
+	// Template endpoint
+	//
+	// Methods:
+	//
+	// POST /template       ' Install the module package and claim
+	// DELETE /template     ' Delete the module package and claim
+	// POST /template/diff  ' Preview the changes the install would make to the live objects,
+	//                      ' as JSON Patch and unified YAML diff (without server-managed fields)
+	//
+	// Payload (by Content-Type):
+	//
+	// application/json     ' {"package": "...", "claim": "...", "encoding": "base64", "namespace": "..."}
+	// application/yaml     ' Multi-document stream: the package first, then the claim objects
+	// multipart/form-data  ' `package` and `claim` parts (i.e. curl -F claim=@claim.yaml)
+	//
+	// Query params:
+	//
+	// wait=true         ' Run the operation bound to the request and reply with its outcome
+	// timeout=5m        ' Max time to wait for the outcome (bounded by the `max-wait` flag)
+	// dryRun=true       ' Validate the objects with `dryRun=All` and reply with the defaulted objects
+	// force=true        ' On install, take the ownership of fields managed by others
+	// keepPackage=true  ' On delete, remove only the claim and keep the package
+	// waitForCRDs=true  ' On delete, wait for the package CRDs to be removed
+	// onConflict=queue  ' Run after the operations in progress on the same claim or packages (default),
+	//                   ' or `reject` them with 409
+	//
+	// The operations run on a pool of `workers`, with up to `queue-size` waiting
+	// for a free one or for the operations on the same module; when the queue
+	// is full the request gets 429 and `Retry-After`.
 	mux.Handle("/template", middlewares.Logger(log)(
 		middlewares.CorrelationID(
-			modules.Create(cfg, bus),
+			modules.Create(opts),
 		),
 	)).Methods(http.MethodPost)
 
 	mux.Handle("/template", middlewares.Logger(log)(
 		middlewares.CorrelationID(
-			modules.Delete(cfg, bus),
+			modules.Delete(opts),
 		),
 	)).Methods(http.MethodDelete)
 
+	mux.Handle("/template/diff", middlewares.Logger(log)(
+		middlewares.CorrelationID(
+			middlewares.Timeout(writeTimeout)(
+				modules.Diff(opts),
+			),
+		),
+	)).Methods(http.MethodPost)
+
+	// Modules endpoint
+	//
+	// Inventory of the packages and claims installed by `/template`, that is
+	// the objects of the allowed kinds labelled `kube-bridge.krateo.io/owned`.
+	//
+	// Methods:
+	//
+	// GET /modules                                            ' List the installed modules
+	// GET /modules/{group}/{version}/{kind}/{name}            ' Get the live claim (in the `namespace` query param)
+	//                                                         ' and its composite tree with Ready/Synced conditions
+	// GET /modules/{group}/{version}/{kind}/{name}/history    ' List the last `history-limit` revisions applied
+	//                                                         ' to the module, with deploymentId, time and caller
+	// POST /modules/{group}/{version}/{kind}/{name}/rollback  ' Re-apply the packages and claim of the revision
+	//                                                         ' in the `revision` query param, as `/template` does
+	//
+	// Query params:
+	//
+	// group=modules.krateo.io  ' Only the kinds in the apiGroup
+	// kind=Core                ' Only the kind
+	// labelSelector=env=dev    ' Only the objects matching the label selector
+	// limit=100                ' Max items per page (up to 500)
+	// continue=...             ' Token of the next page, from the previous response
+	mux.Handle("/modules", middlewares.Logger(log)(
+		middlewares.CorrelationID(
+			middlewares.Timeout(writeTimeout)(
+				modules.List(opts),
+			),
+		),
+	)).Methods(http.MethodGet)
+
+	mux.Handle("/modules/{group}/{version}/{kind}/{name}", middlewares.Logger(log)(
+		middlewares.CorrelationID(
+			middlewares.Timeout(writeTimeout)(
+				modules.GetModule(opts),
+			),
+		),
+	)).Methods(http.MethodGet)
+
+	mux.Handle("/modules/{group}/{version}/{kind}/{name}/history", middlewares.Logger(log)(
+		middlewares.CorrelationID(
+			middlewares.Timeout(writeTimeout)(
+				modules.History(opts),
+			),
+		),
+	)).Methods(http.MethodGet)
+
+	// no Timeout middleware: with `wait=true` the rollback,
+	// as a `/template` install, can run up to `max-wait`
+	mux.Handle("/modules/{group}/{version}/{kind}/{name}/rollback", middlewares.Logger(log)(
+		middlewares.CorrelationID(
+			modules.Rollback(opts),
+		),
+	)).Methods(http.MethodPost)
+
+	// Operations endpoint
+	//
+	// Every `/template` request starts a background operation
+	// identified by the `X-Deployment-Id` header value.
+	//
+	// A request with the same `X-Deployment-Id` and payload of a tracked
+	// operation (running, or finished within `operations-retention`) gets
+	// the operation status instead of starting it again; a different
+	// payload gets 409.
+	//
+	// The operations are persisted to the `operations-store`: the ones still
+	// running when the process died are marked as interrupted on startup, and
+	// are resumed by sending again their `/template` request.
+	//
+	// Methods:
+	//
+	// GET /operations/{deploymentId}     ' Get state, steps and final error of the operation
+	// DELETE /operations/{deploymentId}  ' Cancel the pending or running operation,
+	//                                    ' with `rollback=true` an install deletes the objects it created
+	mux.Handle("/operations/{deploymentId}", middlewares.Logger(log)(
+		middlewares.CorrelationID(
+			middlewares.Timeout(writeTimeout)(
+				modules.GetOperation(reg),
+			),
+		),
+	)).Methods(http.MethodGet)
+
+	mux.Handle("/operations/{deploymentId}", middlewares.Logger(log)(
+		middlewares.CorrelationID(
+			middlewares.Timeout(writeTimeout)(
+				modules.CancelOperation(reg),
+			),
+		),
+	)).Methods(http.MethodDelete)
+
+	// On shutdown the new operations are rejected with 503, the running ones
+	// get up to `shutdown-grace-period` to complete and are then interrupted.
+	//
+	// Synchronous `/template` requests can last up to `max-wait`,
+	// all other routes are bound to `writeTimeout` by middleware.
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", *servicePort),
 		Handler:      mux,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: *maxWait + writeTimeout,
 		IdleTimeout:  20 * time.Second,
 	}
 
@@ -181,13 +469,37 @@ func main() {
 	log.Info().Msg("server is shutting down gracefully, press Ctrl+C again to force")
 	atomic.StoreInt32(&healthy, 0)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), *gracePeriod)
 	defer cancel()
 
+	// Stop accepting requests and operations, then wait for the running ones
 	server.SetKeepAlivesEnabled(false)
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("server forced to shutdown")
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+
+	if err := opWorkers.Stop(ctx); err != nil {
+		log.Warn().Msgf("operations still running after %s", gracePeriod.String())
+	}
+	// also the ones still waiting for the module locks
+	if ids := reg.Interrupt(); len(ids) > 0 {
+		log.Warn().Strs("operations", ids).Msg("operations interrupted by shutdown")
+	}
+	reg.Flush()
+
+	if err := <-shutdownErr; err != nil {
+		log.Error().Err(err).Msg("server forced to shutdown")
+	}
+
+	// Flush the notifications published so far
+	fctx, fcancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer fcancel()
+
+	if err := notifyWorkers.Stop(fctx); err != nil {
+		log.Warn().Interface("notifications", notifyWorkers.Stats()).Msg("notifications not flushed")
 	}
+	reg.Flush()
 
 	log.Info().Msg("server gracefully stopped")
 }
@@ -0,0 +1,86 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var conflictManagerRx = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// fieldConflict is a field owned by another field manager.
+type fieldConflict struct {
+	Manager string `json:"manager"`
+	Field   string `json:"field,omitempty"`
+}
+
+// conflictError reports the fields that a server-side apply
+// could not take over because owned by other managers.
+type conflictError struct {
+	Kind      string          `json:"kind"`
+	Name      string          `json:"name"`
+	Conflicts []fieldConflict `json:"conflicts"`
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("apply conflicts on %s: %s with managers: %s (use force=true to take ownership)",
+		e.Kind, e.Name, strings.Join(e.Managers(), ", "))
+}
+
+// Conflict implements operations.ConflictError.
+func (e *conflictError) Conflict() interface{} {
+	return e
+}
+
+// Managers returns the sorted names of the conflicting field managers.
+func (e *conflictError) Managers() []string {
+	set := map[string]struct{}{}
+	for _, el := range e.Conflicts {
+		set[el.Manager] = struct{}{}
+	}
+
+	res := make([]string, 0, len(set))
+	for k := range set {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func newConflictError(obj *unstructured.Unstructured, err error) error {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return err
+	}
+
+	res := &conflictError{
+		Kind: obj.GetKind(),
+		Name: obj.GetName(),
+	}
+
+	if det := status.Status().Details; det != nil {
+		for _, el := range det.Causes {
+			if el.Type != metav1.CauseTypeFieldManagerConflict {
+				continue
+			}
+
+			fc := fieldConflict{Field: el.Field}
+			if m := conflictManagerRx.FindStringSubmatch(el.Message); len(m) == 2 {
+				fc.Manager = m[1]
+			}
+			res.Conflicts = append(res.Conflicts, fc)
+		}
+	}
+
+	if len(res.Conflicts) == 0 {
+		return err
+	}
+
+	return res
+}
@@ -0,0 +1,50 @@
+package modules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestNewConflictError(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+	obj.SetKind("Core")
+	obj.SetName("krateo-module-core")
+
+	src := apierrors.NewApplyConflict([]metav1.StatusCause{
+		{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "kubectl-edit" using modules.krateo.io/v1alpha1: .spec.organization`,
+			Field:   ".spec.organization",
+		},
+		{
+			Type:    metav1.CauseTypeFieldManagerConflict,
+			Message: `conflict with "argocd-controller": .spec.frontendUrl`,
+			Field:   ".spec.frontendUrl",
+		},
+	}, "Apply failed with 2 conflicts")
+
+	err := newConflictError(obj, src)
+
+	var ce *conflictError
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, 2, len(ce.Conflicts))
+	assert.Equal(t, []string{"argocd-controller", "kubectl-edit"}, ce.Managers())
+	assert.Equal(t, ".spec.organization", ce.Conflicts[0].Field)
+}
+
+func TestNewConflictErrorWithoutCauses(t *testing.T) {
+	obj := &unstructured.Unstructured{}
+
+	src := apierrors.NewConflict(schema.GroupResource{Resource: "cores"}, "x", errors.New("stale"))
+
+	err := newConflictError(obj, src)
+
+	var ce *conflictError
+	assert.False(t, errors.As(err, &ce))
+}
@@ -6,24 +6,30 @@ import (
 	"fmt"
 	"net/http"
 
-	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 
-	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
 	"github.com/krateoplatformops/kube-bridge/pkg/handlers/utils"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/operations"
 	"github.com/krateoplatformops/kube-bridge/pkg/support"
 	"github.com/rs/zerolog"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
 )
 
-func Create(cfg *rest.Config, bus eventbus.Bus) http.Handler {
+func Create(opts Options) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := zerolog.Ctx(r.Context())
 
+		prm, err := parseParams(r, opts)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		var sd payload
-		err := utils.DecodeJSONBody(w, r, &sd)
+		err = decodePayload(w, r, &sd, opts.MaxBodySize)
 		if err != nil {
 			log.Warn().Msg(err.Error())
 
@@ -36,98 +42,200 @@ func Create(cfg *rest.Config, bus eventbus.Bus) http.Handler {
 			return
 		}
 
-		pkgObj, _, err := decodeModulePackage(sd.Package)
+		pci, err := decodeModuleBundle(&sd, opts.Policy.Rules())
+		if err == nil {
+			err = checkClaimScope(opts.Clients, pci.clmObj)
+		}
 		if err != nil {
 			log.Error().Msg(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), decodeErrorStatus(err))
 			return
 		}
-		log.Info().Str("name", pkgObj.GetName()).Msg("decoded package data")
+		logBundle(log, pci)
+
+		startInstall(w, r, opts, prm, pci)
+	})
+}
+
+// startInstall runs the dry-run, or begins and dispatches
+// the install operation of the decoded module.
+func startInstall(w http.ResponseWriter, r *http.Request, opts Options, prm *params, pci *packageAndClaimInfo) {
+	log := zerolog.Ctx(r.Context())
+
+	if prm.dryRun {
+		writeDryRun(w, dryRunInstall(r.Context(), opts.Bus, opts.Clients, pci, prm))
+		return
+	}
+
+	op, existing, err := opts.Registry.Begin(middlewares.DeploymentID(r.Context()), operations.KindInstall, payloadDigest(pci))
+	if err != nil {
+		log.Warn().Msg(err.Error())
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 
-		clmObj, clmGVK, err := decodeModuleClaim(sd.Claim)
+	if existing {
+		log.Info().Str("state", string(op.State)).Msg("operation already started, replying with its status")
+		writeExisting(w, op)
+		return
+	}
+
+	mj, err := serialize(opts, prm, moduleKeys(pci), func(ctx context.Context) error {
+		created, err := installPackageAndClaim(ctx, opts, pci, prm)
+		if err != nil && cancelled(ctx, opts) {
+			if opts.Registry.Rollback(op.ID) {
+				return rollbackInstall(ctx, opts, created, err)
+			}
+			return err
+		}
 		if err != nil {
 			log.Error().Msg(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		log.Info().
-			Str("group", clmGVK.Group).
-			Str("version", clmGVK.Version).
-			Str("kind", clmGVK.Kind).
-			Str("name", clmObj.GetName()).
-			Msg("decoded claim data")
-
-		pci := &packageAndClaimInfo{
-			pkgObj: pkgObj,
-			clmGVK: clmGVK,
-			clmObj: clmObj,
+			opts.Bus.Publish(support.ErrorNotification(ctx, support.ReasonFailure, err))
+			return err
 		}
 
-		go func() {
-			ctx := valueOnlyContext{r.Context()}
+		recordRevision(ctx, opts, pci, prm)
 
-			err = installPackageAndClaim(ctx, bus, cfg, pci)
-			if err != nil {
-				log.Error().Msg(err.Error())
-				bus.Publish(support.ErrorNotification(ctx, support.ReasonFailure, err))
-				//http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		msg := fmt.Sprintf("packages: %s and claim: %s successfully installed", objectNames(pci.pkgObjs), pci.clmObj.GetName())
+		opts.Bus.Publish(support.InfoNotification(ctx, support.ReasonSuccess, msg))
+		return nil
+	})
+	if err != nil {
+		opts.Registry.Discard(op.ID)
+		log.Warn().Msg(err.Error())
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 
-			msg := fmt.Sprintf("package: %s and claim: %s successfully installed", pkgObj.GetName(), clmObj.GetName())
-			bus.Publish(support.InfoNotification(ctx, support.ReasonSuccess, msg))
-		}()
+	inspect := func(ctx context.Context) []kubernetes.Condition {
+		return claimConditions(ctx, opts.Clients, pci.clmObj)
+	}
 
-		w.WriteHeader(http.StatusOK)
-	})
+	dispatch(w, r, opts, prm, op, mj, inspect)
 }
 
-type packageAndClaimInfo struct {
-	pkgObj *unstructured.Unstructured
-	clmGVK *schema.GroupVersionKind
-	clmObj *unstructured.Unstructured
+// installPackageAndClaim installs the packages one at a time, waiting for
+// each of them to become healthy, then applies the supporting objects and
+// the claim in dependency order. All of them are marked as owned by the
+// bridge, so that they are listed by the `/modules` inventory. The objects
+// that did not exist before are returned as created, also on failure.
+func installPackageAndClaim(ctx context.Context, opts Options, pci *packageAndClaimInfo, prm *params) (*packageAndClaimInfo, error) {
+	bus, kf := opts.Bus, opts.Clients
+
+	ao := resourceOptions{force: prm.force}
+
+	created := &packageAndClaimInfo{clmGVK: pci.clmGVK, clmObj: pci.clmObj}
+
+	for _, pkg := range pci.pkgObjs {
+		_, ok, err := applyResourceFromUnstructured(ctx, bus, kf, owned(ctx, pkg), ao)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created.pkgObjs = append(created.pkgObjs, pkg)
+		}
+
+		msg := fmt.Sprintf("Waiting for package: %s to become Installed and Healthy", pkg.GetName())
+		bus.Publish(support.InfoNotification(ctx, support.ReasonWaitForResource, msg).WithResource(pkg))
+
+		err = waitForPackageHealthy(ctx, bus, kf, pkg, defaultMaxWait)
+		if err != nil {
+			return created, err
+		}
+	}
+
+	for i, obj := range pci.objs {
+		msg := fmt.Sprintf("Applying resource %d of %d (apiVersion: %s, kind: %s, name: %s)",
+			i+1, len(pci.objs), obj.GetAPIVersion(), obj.GetKind(), obj.GetName())
+		bus.Publish(support.InfoNotification(ctx, support.ReasonApplyingResource, msg))
+
+		err := waitForServed(ctx, opts, obj)
+		if err != nil {
+			return created, err
+		}
+
+		_, ok, err := applyResourceFromUnstructured(ctx, bus, kf, owned(ctx, obj), ao)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created.objs = append(created.objs, obj)
+		}
+	}
+
+	if prm.readyTimeout <= 0 {
+		return created, nil
+	}
+
+	msg := fmt.Sprintf("Waiting for claim: %s to become Ready and Synced", pci.clmObj.GetName())
+	bus.Publish(support.InfoNotification(ctx, support.ReasonWaitForResource, msg).WithResource(pci.clmObj))
+
+	return created, waitForClaimReady(ctx, bus, kf, pci.clmObj, prm.readyTimeout)
 }
 
-func installPackageAndClaim(ctx context.Context, bus eventbus.Bus, cfg *rest.Config, pci *packageAndClaimInfo) error {
+// rollbackInstall deletes the objects created by a cancelled install in
+// reverse order, unbound from the cancellation of the operation context.
+func rollbackInstall(ctx context.Context, opts Options, created *packageAndClaimInfo, cause error) error {
 	log := zerolog.Ctx(ctx)
-	dc, err := dynamic.NewForConfig(cfg)
+
+	ctx, cancel := context.WithTimeout(valueOnlyContext{ctx}, defaultMaxWait)
+	defer cancel()
+
+	log.Info().
+		Int("packages", len(created.pkgObjs)).
+		Int("objects", len(created.objs)).
+		Msg("rolling back cancelled install")
+
+	err := deletePackageAndClaim(ctx, opts, created, &params{})
 	if err != nil {
-		return err
+		return fmt.Errorf("%v, rollback failed: %w", cause, err)
 	}
 
-	err = createOrUpdateResourceFromUnstructured(context.Background(), bus, cfg, dc, pci.pkgObj)
-	if err != nil {
+	return fmt.Errorf("%w, rolled back", cause)
+}
+
+// waitForServed waits for the CRD defining the object kind, when
+// the kind is not served yet (i.e. defined by a package just installed).
+func waitForServed(ctx context.Context, opts Options, obj *unstructured.Unstructured) error {
+	log := zerolog.Ctx(ctx)
+
+	gvk := obj.GroupVersionKind()
+	_, err := opts.Clients.RESTMapping(gvk)
+	if !meta.IsNoMatchError(err) {
 		return err
 	}
 
-	crdi := buildCRDInfo(pci.clmGVK)
+	gv := gvk.GroupVersion().String()
 
-	msg := fmt.Sprintf("Waiting for Resource (apiVersion: %s, kind: %s)", crdi.APIVersion, crdi.Spec.Names.Kind)
-	bus.Publish(support.InfoNotification(context.Background(), support.ReasonWaitForResource, msg))
+	msg := fmt.Sprintf("Waiting for Resource (apiVersion: %s, kind: %s)", gv, gvk.Kind)
+	opts.Bus.Publish(support.InfoNotification(ctx, support.ReasonWaitForResource, msg))
 
 	log.Info().
-		Str("apiVersion", crdi.APIVersion).
-		Str("kind", crdi.Spec.Names.Kind).
-		Str("plurals", crdi.Spec.Names.Plural).
+		Str("apiVersion", gv).
+		Str("kind", gvk.Kind).
 		Msg("Waiting for CRD")
-	err = waitForCRDs(cfg, []*apiextensionsv1.CustomResourceDefinition{crdi})
+	crd, err := waitForKind(ctx, opts.Crds, &gvk)
 	if err != nil {
 		return err
 	}
 	log.Info().
-		Str("apiVersion", crdi.APIVersion).
-		Str("kind", crdi.Spec.Names.Kind).
-		Str("plurals", crdi.Spec.Names.Plural).
+		Str("apiVersion", gv).
+		Str("kind", gvk.Kind).
+		Str("plurals", crd.Spec.Names.Plural).
 		Msg("CRD ready")
 
-	msg = fmt.Sprintf("Resource ready (apiVersion: %s, kind: %s)", crdi.APIVersion, crdi.Spec.Names.Kind)
-	bus.Publish(support.InfoNotification(context.Background(), support.ReasonSuccess, msg))
+	msg = fmt.Sprintf("Resource ready (apiVersion: %s, kind: %s)", gv, gvk.Kind)
+	opts.Bus.Publish(support.InfoNotification(ctx, support.ReasonSuccess, msg))
 
-	return createOrUpdateResourceFromUnstructured(context.Background(), bus, cfg, dc, pci.clmObj)
+	return nil
 }
 
-type payload struct {
-	Claim    string `json:"claim"`
-	Package  string `json:"package"`
-	Encoding string `json:"encoding"`
+// claimConditions returns the live status conditions of the claim.
+func claimConditions(ctx context.Context, kf kubernetes.Factory, clmObj *unstructured.Unstructured) []kubernetes.Condition {
+	res, err := getResource(ctx, kf, clmObj)
+	if err != nil {
+		return nil
+	}
+
+	return kubernetes.Conditions(res)
 }
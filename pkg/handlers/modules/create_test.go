@@ -1,10 +1,17 @@
 package modules
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"testing"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func TestB64(t *testing.T) {
@@ -34,3 +41,25 @@ func TestB64(t *testing.T) {
 	}
 	t.Logf("%s", js)
 }
+
+func TestRollbackInstall(t *testing.T) {
+	clm := newObject(claimGVK, "core", "demo")
+	kf := newFakeFactory(clm.DeepCopy())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opts := Options{Clients: kf, Bus: eventbus.New()}
+	created := &packageAndClaimInfo{
+		clmGVK: &claimGVK,
+		clmObj: clm,
+		objs:   []*unstructured.Unstructured{clm},
+	}
+
+	err := rollbackInstall(ctx, opts, created, ctx.Err())
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Contains(t, err.Error(), "rolled back")
+
+	_, err = getResource(context.Background(), kf, clm)
+	assert.True(t, apierrors.IsNotFound(err))
+}
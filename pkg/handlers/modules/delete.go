@@ -5,21 +5,36 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
-	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
 	"github.com/krateoplatformops/kube-bridge/pkg/handlers/utils"
+	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/operations"
 	"github.com/krateoplatformops/kube-bridge/pkg/support"
 	"github.com/rs/zerolog"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-func Delete(cfg *rest.Config, bus eventbus.Bus) http.Handler {
+// kindXRD is the kind of the Crossplane CompositeResourceDefinitions,
+// owning the CRDs of the composites and claims they define.
+const kindXRD = "CompositeResourceDefinition"
+
+func Delete(opts Options) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := zerolog.Ctx(r.Context())
 
+		prm, err := parseParams(r, opts)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		var sd payload
-		err := utils.DecodeJSONBody(w, r, &sd)
+		err = decodePayload(w, r, &sd, opts.MaxBodySize)
 		if err != nil {
 			log.Warn().Msg(err.Error())
 
@@ -32,61 +47,218 @@ func Delete(cfg *rest.Config, bus eventbus.Bus) http.Handler {
 			return
 		}
 
-		pkgObj, _, err := decodeModulePackage(sd.Package)
+		pci, err := decodeModuleBundle(&sd, opts.Policy.Rules())
+		if err == nil {
+			err = checkClaimScope(opts.Clients, pci.clmObj)
+		}
 		if err != nil {
 			log.Error().Msg(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), decodeErrorStatus(err))
 			return
 		}
-		log.Info().Str("name", pkgObj.GetName()).Msg("decoded package data")
+		logBundle(log, pci)
 
-		clmObj, clmGVK, err := decodeModuleClaim(sd.Claim)
-		if err != nil {
-			log.Error().Msg(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if prm.dryRun {
+			writeDryRun(w, dryRunDelete(r.Context(), opts.Bus, opts.Clients, pci, prm))
 			return
 		}
-		log.Info().
-			Str("group", clmGVK.Group).
-			Str("version", clmGVK.Version).
-			Str("kind", clmGVK.Kind).
-			Str("name", clmObj.GetName()).
-			Msg("decoded claim data")
 
-		pci := &packageAndClaimInfo{
-			pkgObj: pkgObj,
-			clmGVK: clmGVK,
-			clmObj: clmObj,
+		op, existing, err := opts.Registry.Begin(middlewares.DeploymentID(r.Context()), operations.KindDelete, payloadDigest(pci))
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
 		}
 
-		go func() {
-			ctx := valueOnlyContext{r.Context()}
+		if existing {
+			log.Info().Str("state", string(op.State)).Msg("operation already started, replying with its status")
+			writeExisting(w, op)
+			return
+		}
 
-			err = deletePackageAndClaim(ctx, bus, cfg, pci)
+		mj, err := serialize(opts, prm, moduleKeys(pci), func(ctx context.Context) error {
+			err := deletePackageAndClaim(ctx, opts, pci, prm)
+			if err != nil && cancelled(ctx, opts) {
+				return err
+			}
 			if err != nil {
 				log.Error().Msg(err.Error())
-				bus.Publish(support.ErrorNotification(ctx, support.ReasonFailure, err))
-				return
+				opts.Bus.Publish(support.ErrorNotification(ctx, support.ReasonFailure, err))
+				return err
 			}
 
-			msg := fmt.Sprintf("package: %s and claim: %s successfully deleted", pkgObj.GetName(), clmObj.GetName())
-			bus.Publish(support.InfoNotification(ctx, support.ReasonSuccess, msg))
-		}()
+			msg := fmt.Sprintf("packages: %s and claim: %s successfully deleted", objectNames(pci.pkgObjs), pci.clmObj.GetName())
+			if prm.keepPackage {
+				msg = fmt.Sprintf("claim: %s successfully deleted, packages: %s kept", pci.clmObj.GetName(), objectNames(pci.pkgObjs))
+			}
+			opts.Bus.Publish(support.InfoNotification(ctx, support.ReasonSuccess, msg))
+			return nil
+		})
+		if err != nil {
+			opts.Registry.Discard(op.ID)
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 
-		w.WriteHeader(http.StatusOK)
+		dispatch(w, r, opts, prm, op, mj, nil)
 	})
 }
 
-func deletePackageAndClaim(ctx context.Context, bus eventbus.Bus, cfg *rest.Config, pci *packageAndClaimInfo) error {
-	dc, err := dynamic.NewForConfig(cfg)
-	if err != nil {
-		return err
+// deletePackageAndClaim deletes the objects in reverse install order: first
+// the claim, waiting for it and all its composed resources to be gone, then
+// the supporting objects and finally the packages.
+func deletePackageAndClaim(ctx context.Context, opts Options, pci *packageAndClaimInfo, prm *params) error {
+	log := zerolog.Ctx(ctx)
+	bus, kf := opts.Bus, opts.Clients
+
+	// collect the composite tree before the claim disappears
+	pending := []*unstructured.Unstructured{pci.clmObj}
+	if live, err := getResource(ctx, kf, pci.clmObj); err == nil {
+		if xr := resourceRef(live); xr != nil {
+			pending = append(pending, composedObjects(ctx, kf, xr, 1, map[string]bool{})...)
+		}
+	}
+
+	// and the package kinds before their revisions disappear
+	var kinds []schema.GroupVersionKind
+	if !prm.keepPackage && prm.waitForCRDs {
+		kinds = packageKinds(ctx, opts, pci)
+	}
+
+	objs := deleteOrder(pci, prm.keepPackage)
+	for i, obj := range objs {
+		msg := fmt.Sprintf("Deleting resource %d of %d (apiVersion: %s, kind: %s, name: %s)",
+			i+1, len(objs), obj.GetAPIVersion(), obj.GetKind(), obj.GetName())
+		bus.Publish(support.InfoNotification(ctx, support.ReasonDeletingResource, msg))
+
+		_, err := deleteResourceFromUnstructured(ctx, bus, kf, obj, resourceOptions{})
+		if err != nil && !meta.IsNoMatchError(err) {
+			return err
+		}
+
+		if obj != pci.clmObj {
+			continue
+		}
+
+		msg = fmt.Sprintf("Waiting for claim: %s and %d composed resources deletion", pci.clmObj.GetName(), len(pending)-1)
+		bus.Publish(support.InfoNotification(ctx, support.ReasonWaitForResource, msg))
+
+		log.Info().
+			Str("name", pci.clmObj.GetName()).
+			Int("composed", len(pending)-1).
+			Msg("Waiting for claim deletion")
+		err = waitForDeletion(ctx, kf, pending)
+		if err != nil {
+			return fmt.Errorf("waiting for claim: %s deletion: %w", pci.clmObj.GetName(), err)
+		}
 	}
 
-	err = deleteResourceFromUnstructured(ctx, bus, cfg, dc, pci.clmObj)
-	if err != nil {
-		return err
+	if prm.keepPackage || !prm.waitForCRDs {
+		return nil
 	}
 
-	return createOrUpdateResourceFromUnstructured(ctx, bus, cfg, dc, pci.pkgObj)
+	for _, el := range kinds {
+		msg := fmt.Sprintf("Waiting for Resource removal (apiVersion: %s, kind: %s)", el.GroupVersion().String(), el.Kind)
+		bus.Publish(support.InfoNotification(ctx, support.ReasonWaitForResource, msg))
+	}
+
+	return waitForCRDsRemoval(ctx, opts.Crds, kinds)
+}
+
+// packageKinds returns the kinds defined by the CRDs the packages own,
+// either directly through their revisions (i.e. providers) or through
+// the CompositeResourceDefinitions their revisions own (i.e. configurations).
+// The claim kind is always part of the result.
+func packageKinds(ctx context.Context, opts Options, pci *packageAndClaimInfo) []schema.GroupVersionKind {
+	log := zerolog.Ctx(ctx)
+
+	res := []schema.GroupVersionKind{*pci.clmGVK}
+	if opts.Crds == nil {
+		return res
+	}
+
+	// CompositeResourceDefinitions already looked up, by name
+	xrds := map[string]bool{}
+	ownedByXRD := func(ref metav1.OwnerReference) bool {
+		if ref.Kind != kindXRD {
+			return false
+		}
+
+		owned, ok := xrds[ref.Name]
+		if ok {
+			return owned
+		}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(ref.APIVersion)
+		obj.SetKind(ref.Kind)
+		obj.SetName(ref.Name)
+
+		live, err := getResource(ctx, opts.Clients, obj)
+		if err != nil {
+			log.Warn().Msgf("looking up %s: %s: %s", ref.Kind, ref.Name, err.Error())
+		}
+		owned = err == nil && ownedByPackage(live.GetOwnerReferences(), pci.pkgObjs)
+		xrds[ref.Name] = owned
+		return owned
+	}
+
+	for _, crd := range opts.Crds.List() {
+		gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: servedVersion(crd), Kind: crd.Spec.Names.Kind}
+		if gvk.GroupKind() == pci.clmGVK.GroupKind() {
+			continue
+		}
+
+		refs := crd.GetOwnerReferences()
+		if ownedByPackage(refs, pci.pkgObjs) {
+			res = append(res, gvk)
+			continue
+		}
+
+		for _, el := range refs {
+			if ownedByXRD(el) {
+				res = append(res, gvk)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// ownedByPackage reports whether one of the owners is a revision of the
+// packages. Crossplane names the revisions `<package name>-<digest>`.
+func ownedByPackage(refs []metav1.OwnerReference, pkgObjs []*unstructured.Unstructured) bool {
+	for _, ref := range refs {
+		for _, pkg := range pkgObjs {
+			if ref.Kind != pkg.GetKind()+"Revision" {
+				continue
+			}
+
+			digest := strings.TrimPrefix(ref.Name, pkg.GetName()+"-")
+			if digest != ref.Name && len(digest) > 0 && !strings.Contains(digest, "-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deleteOrder returns the objects in reverse install order, that
+// is the claim first and the packages (unless kept) last.
+func deleteOrder(pci *packageAndClaimInfo, keepPackage bool) []*unstructured.Unstructured {
+	res := make([]*unstructured.Unstructured, 0, len(pci.objs)+len(pci.pkgObjs))
+	for i := len(pci.objs) - 1; i >= 0; i-- {
+		res = append(res, pci.objs[i])
+	}
+
+	if keepPackage {
+		return res
+	}
+
+	for i := len(pci.pkgObjs) - 1; i >= 0; i-- {
+		res = append(res, pci.pkgObjs[i])
+	}
+	return res
 }
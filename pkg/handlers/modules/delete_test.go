@@ -0,0 +1,58 @@
+package modules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestPackageKinds(t *testing.T) {
+	xrdGVK := schema.GroupVersionKind{Group: "apiextensions.crossplane.io", Version: "v1", Kind: kindXRD}
+	bucketGVK := schema.GroupVersionKind{Group: "s3.aws.crossplane.io", Version: "v1beta1", Kind: "Bucket"}
+	otherGVK := schema.GroupVersionKind{Group: "modules.krateo.io", Version: "v1alpha1", Kind: "XOther"}
+
+	xrd := newObject(xrdGVK, "xcores.modules.krateo.io", "")
+	xrd.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "pkg.crossplane.io/v1", Kind: "ConfigurationRevision", Name: "core-3f9a1c2d7b6e"},
+	})
+
+	kf := newFakeFactory(xrd)
+	kf.mapper.(*meta.DefaultRESTMapper).Add(xrdGVK, meta.RESTScopeRoot)
+
+	byXRD := []metav1.OwnerReference{{APIVersion: xrdGVK.GroupVersion().String(), Kind: kindXRD, Name: xrd.GetName()}}
+
+	claim := newCRD(claimGVK, "cores", apiextensionsv1.NamespaceScoped)
+	claim.SetOwnerReferences(byXRD)
+	composite := newCRD(compositeGVK, "xcores", apiextensionsv1.ClusterScoped)
+	composite.SetOwnerReferences(byXRD)
+	bucket := newCRD(bucketGVK, "buckets", apiextensionsv1.ClusterScoped)
+	bucket.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "pkg.crossplane.io/v1", Kind: "ConfigurationRevision", Name: "core-3f9a1c2d7b6e"},
+	})
+	// owned by a revision of another package
+	other := newCRD(otherGVK, "xothers", apiextensionsv1.ClusterScoped)
+	other.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "pkg.crossplane.io/v1", Kind: "ConfigurationRevision", Name: "core-extras-0a1b2c3d4e5f"},
+	})
+
+	opts := Options{
+		Clients: kf,
+		Crds:    &fakeCrds{crds: []*apiextensionsv1.CustomResourceDefinition{claim, composite, bucket, other}},
+	}
+
+	gvk := claimGVK
+	pci := &packageAndClaimInfo{
+		pkgObjs: []*unstructured.Unstructured{newObject(configurationGVK, "core", "")},
+		clmObj:  newObject(claimGVK, "core", "demo"),
+		clmGVK:  &gvk,
+	}
+
+	res := packageKinds(context.Background(), opts, pci)
+	assert.Equal(t, []schema.GroupVersionKind{claimGVK, compositeGVK, bucketGVK}, res)
+}
@@ -0,0 +1,332 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/handlers/utils"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	diffCreate    = "create"
+	diffUpdate    = "update"
+	diffUnchanged = "unchanged"
+)
+
+// serverFields are the fields set by the cluster, left out of the diff.
+var serverFields = [][]string{
+	{"status"},
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "selfLink"},
+}
+
+// patchOp is a RFC 6902 JSON Patch operation.
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON leaves out the value of the `remove` operations, keeping
+// the zero values (i.e. `false` or `null`) of the other ones.
+func (op patchOp) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(map[string]string{"op": op.Op, "path": op.Path})
+	}
+
+	type plain patchOp
+	return json.Marshal(plain(op))
+}
+
+// diffObject is the change an install would make to a single object.
+type diffObject struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+	// Operation is `create`, `update` or `unchanged`.
+	Operation string `json:"operation,omitempty"`
+	// Patch turns the live object into the applied one.
+	Patch []patchOp `json:"patch,omitempty"`
+	// Diff is the unified diff of the live and applied YAML.
+	Diff    string `json:"diff,omitempty"`
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// diffResult is the outcome of a `/template/diff` request.
+type diffResult struct {
+	Objects []diffObject `json:"objects"`
+}
+
+func (res *diffResult) failed() bool {
+	for _, el := range res.Objects {
+		if len(el.Error) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff previews the changes the `/template` install of the payload would
+// make: each object is applied with `dryRun=All` and compared to the live
+// one, leaving out the fields managed by the cluster.
+func Diff(opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		prm, err := parseParams(r, opts)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var sd payload
+		err = decodePayload(w, r, &sd, opts.MaxBodySize)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+
+			var mr *utils.MalformedRequest
+			if errors.As(err, &mr) {
+				http.Error(w, mr.Msg, mr.Status)
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		pci, err := decodeModuleBundle(&sd, opts.Policy.Rules())
+		if err == nil {
+			err = checkClaimScope(opts.Clients, pci.clmObj)
+		}
+		if err != nil {
+			log.Error().Msg(err.Error())
+			http.Error(w, err.Error(), decodeErrorStatus(err))
+			return
+		}
+		logBundle(log, pci)
+
+		res := diffInstall(r.Context(), opts, pci, prm)
+
+		status := http.StatusOK
+		if res.failed() {
+			status = http.StatusUnprocessableEntity
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		err = json.NewEncoder(w).Encode(res)
+		if err != nil {
+			log.Error().Msg(err.Error())
+		}
+	})
+}
+
+// diffInstall compares the live packages and claim objects with the
+// outcome of their dry-run apply. The objects are marked as owned as
+// the install does, so that the apply removes the same fields. Secrets
+// are skipped, not to disclose their live data, as well as the objects
+// whose kind is not served yet.
+func diffInstall(ctx context.Context, opts Options, pci *packageAndClaimInfo, prm *params) *diffResult {
+	ro := resourceOptions{force: prm.force, dryRun: true}
+	res := &diffResult{}
+
+	all := append(append([]*unstructured.Unstructured{}, pci.pkgObjs...), pci.objs...)
+	for _, obj := range all {
+		el := diffObject{
+			Kind:       obj.GetKind(),
+			APIVersion: obj.GetAPIVersion(),
+			Name:       obj.GetName(),
+			Namespace:  obj.GetNamespace(),
+		}
+
+		gvk := obj.GroupVersionKind()
+		if gvk.Group == "" && gvk.Kind == "Secret" {
+			el.Skipped = "secrets are not compared"
+			res.Objects = append(res.Objects, el)
+			continue
+		}
+
+		live, err := getResource(ctx, opts.Clients, obj)
+		if meta.IsNoMatchError(err) {
+			el.Skipped = fmt.Sprintf("kind: %s in apiGroup: %s is not served, packages: %s are not installed",
+				gvk.Kind, gvk.Group, objectNames(pci.pkgObjs))
+			res.Objects = append(res.Objects, el)
+			continue
+		}
+		if apierrors.IsNotFound(err) {
+			live, err = nil, nil
+		}
+		if err != nil {
+			el.Error = err.Error()
+			res.Objects = append(res.Objects, el)
+			continue
+		}
+
+		out, _, err := applyResourceFromUnstructured(ctx, opts.Bus, opts.Clients, owned(ctx, obj), ro)
+		if err != nil {
+			el.Error = err.Error()
+			res.Objects = append(res.Objects, el)
+			continue
+		}
+
+		err = compareObjects(&el, live, out)
+		if err != nil {
+			el.Error = err.Error()
+		}
+		res.Objects = append(res.Objects, el)
+	}
+
+	return res
+}
+
+// compareObjects fills the operation, patch and diff turning
+// the live object (nil when missing) into the applied one.
+func compareObjects(el *diffObject, live, out *unstructured.Unstructured) error {
+	from := map[string]interface{}{}
+	if live != nil {
+		from = withoutServerFields(live).Object
+	}
+	to := withoutServerFields(out).Object
+
+	el.Patch = jsonPatch("", from, to)
+
+	switch {
+	case live == nil:
+		el.Operation = diffCreate
+	case len(el.Patch) == 0:
+		el.Operation = diffUnchanged
+		return nil
+	default:
+		el.Operation = diffUpdate
+	}
+
+	var a []byte
+	if live != nil {
+		var err error
+		if a, err = yaml.Marshal(from); err != nil {
+			return err
+		}
+	}
+
+	b, err := yaml.Marshal(to)
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToLower(el.Kind) + "/" + el.Name
+	el.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: "live/" + name,
+		ToFile:   "applied/" + name,
+		Context:  3,
+	})
+	return err
+}
+
+// withoutServerFields returns a copy of the object without the serverFields
+// and the bridge owned label and annotation, whose deployment id changes
+// on every request.
+func withoutServerFields(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	res := obj.DeepCopy()
+	for _, el := range serverFields {
+		unstructured.RemoveNestedField(res.Object, el...)
+	}
+
+	labels := res.GetLabels()
+	delete(labels, kubernetes.LabelOwned)
+	if len(labels) == 0 {
+		labels = nil
+	}
+	res.SetLabels(labels)
+
+	annotations := res.GetAnnotations()
+	delete(annotations, kubernetes.AnnotationDeploymentID)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	res.SetAnnotations(annotations)
+
+	return res
+}
+
+// jsonPatch returns the JSON Patch turning from into to. Object keys are
+// compared one by one, in sorted order; lists of different length are
+// replaced as a whole.
+func jsonPatch(path string, from, to interface{}) []patchOp {
+	switch a := from.(type) {
+	case map[string]interface{}:
+		b, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		res := []patchOp{}
+		for _, k := range keys {
+			p := path + "/" + escapePointer(k)
+			av, inA := a[k]
+			bv, inB := b[k]
+			switch {
+			case !inB:
+				res = append(res, patchOp{Op: "remove", Path: p})
+			case !inA:
+				res = append(res, patchOp{Op: "add", Path: p, Value: bv})
+			default:
+				res = append(res, jsonPatch(p, av, bv)...)
+			}
+		}
+		return res
+	case []interface{}:
+		b, ok := to.([]interface{})
+		if !ok || len(a) != len(b) {
+			break
+		}
+
+		res := []patchOp{}
+		for i := range a {
+			res = append(res, jsonPatch(path+"/"+strconv.Itoa(i), a[i], b[i])...)
+		}
+		return res
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+	return []patchOp{{Op: "replace", Path: path, Value: to}}
+}
+
+// escapePointer escapes a key as JSON Pointer (RFC 6901) reference token.
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
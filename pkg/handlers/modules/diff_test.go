@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestJSONPatch(t *testing.T) {
+	from := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"app.kubernetes.io/name": "core", "env": "dev"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"tags":     []interface{}{"a", "b"},
+			"ports":    []interface{}{int64(80)},
+		},
+	}
+	to := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"app.kubernetes.io/name": "krateo"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"tags":     []interface{}{"a", "c"},
+			"ports":    []interface{}{int64(80), int64(443)},
+			"debug":    false,
+		},
+	}
+
+	res := jsonPatch("", from, to)
+	assert.Equal(t, []patchOp{
+		{Op: "replace", Path: "/metadata/labels/app.kubernetes.io~1name", Value: "krateo"},
+		{Op: "remove", Path: "/metadata/labels/env"},
+		{Op: "add", Path: "/spec/debug", Value: false},
+		{Op: "replace", Path: "/spec/ports", Value: []interface{}{int64(80), int64(443)}},
+		{Op: "replace", Path: "/spec/replicas", Value: int64(2)},
+		{Op: "replace", Path: "/spec/tags/1", Value: "c"},
+	}, res)
+
+	dat, err := json.Marshal(res[1:3])
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"op": "remove", "path": "/metadata/labels/env"}, {"op": "add", "path": "/spec/debug", "value": false}]`, string(dat))
+
+	assert.Empty(t, jsonPatch("", from, from))
+}
+
+func TestCompareObjects(t *testing.T) {
+	live := newObject(claimGVK, "core", "demo")
+	live.SetResourceVersion("42")
+	live.SetUID("5c8a9b")
+	unstructured.SetNestedField(live.Object, int64(1), "spec", "replicas")
+	withConditions(live, map[string]string{conditionReady: "True"})
+
+	out := live.DeepCopy()
+	out.SetResourceVersion("43")
+
+	el := &diffObject{Kind: "Core", Name: "core"}
+	assert.Nil(t, compareObjects(el, live, out))
+	assert.Equal(t, diffUnchanged, el.Operation)
+	assert.Empty(t, el.Patch)
+	assert.Empty(t, el.Diff)
+
+	unstructured.SetNestedField(out.Object, int64(2), "spec", "replicas")
+	unstructured.RemoveNestedField(out.Object, "status")
+
+	el = &diffObject{Kind: "Core", Name: "core"}
+	assert.Nil(t, compareObjects(el, live, out))
+	assert.Equal(t, diffUpdate, el.Operation)
+	assert.Equal(t, []patchOp{{Op: "replace", Path: "/spec/replicas", Value: int64(2)}}, el.Patch)
+	assert.Contains(t, el.Diff, "--- live/core/core\n+++ applied/core/core\n")
+	assert.Contains(t, el.Diff, "-  replicas: 1\n+  replicas: 2\n")
+
+	el = &diffObject{Kind: "Core", Name: "core"}
+	assert.Nil(t, compareObjects(el, nil, out))
+	assert.Equal(t, diffCreate, el.Operation)
+	assert.Contains(t, el.Diff, "+kind: Core\n")
+	assert.NotContains(t, el.Diff, "resourceVersion")
+}
+
+func TestCompareObjectsInstalled(t *testing.T) {
+	live := ownedBy(newObject(claimGVK, "core", "demo"), "abc")
+	live.SetResourceVersion("42")
+	unstructured.SetNestedField(live.Object, int64(1), "spec", "replicas")
+
+	out := ownedBy(live.DeepCopy(), "def")
+
+	el := &diffObject{Kind: "Core", Name: "core"}
+	assert.Nil(t, compareObjects(el, live, out))
+	assert.Equal(t, diffUnchanged, el.Operation)
+	assert.Empty(t, el.Patch)
+
+	// objects not installed by the bridge get only the owned metadata
+	el = &diffObject{Kind: "Core", Name: "core"}
+	assert.Nil(t, compareObjects(el, withoutServerFields(live), out))
+	assert.Equal(t, diffUnchanged, el.Operation)
+
+	// the labels set by the caller are still compared
+	labels := out.GetLabels()
+	labels["env"] = "prod"
+	out.SetLabels(labels)
+
+	el = &diffObject{Kind: "Core", Name: "core"}
+	assert.Nil(t, compareObjects(el, live, out))
+	assert.Equal(t, []patchOp{{Op: "replace", Path: "/metadata/labels/env", Value: "prod"}}, el.Patch)
+}
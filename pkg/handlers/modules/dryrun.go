@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// dryRunObject is the outcome of a dry-run request for a single object.
+type dryRunObject struct {
+	Kind       string                     `json:"kind"`
+	APIVersion string                     `json:"apiVersion"`
+	Name       string                     `json:"name"`
+	Object     *unstructured.Unstructured `json:"object,omitempty"`
+	Skipped    string                     `json:"skipped,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+// dryRunResult is the outcome of a `dryRun=true` request.
+type dryRunResult struct {
+	Objects []dryRunObject `json:"objects"`
+
+	errs []error
+}
+
+// status returns 200 when all the objects are accepted, 422 when any of
+// them is rejected by the apiserver (i.e. invalid or conflicting) and 500
+// when any of them could not be checked at all.
+func (res *dryRunResult) status() int {
+	status := http.StatusOK
+	for _, err := range res.errs {
+		if rejected(err) {
+			return http.StatusUnprocessableEntity
+		}
+		status = http.StatusInternalServerError
+	}
+	return status
+}
+
+// rejected reports whether the apiserver refused the object itself.
+func rejected(err error) bool {
+	var ce *conflictError
+	return apierrors.IsInvalid(err) || apierrors.IsBadRequest(err) ||
+		apierrors.IsConflict(err) || errors.As(err, &ce)
+}
+
+func (res *dryRunResult) add(obj, out *unstructured.Unstructured, err error) {
+	el := dryRunObject{
+		Kind:       obj.GetKind(),
+		APIVersion: obj.GetAPIVersion(),
+		Name:       obj.GetName(),
+		Object:     out,
+	}
+	if err != nil {
+		el.Error = err.Error()
+		res.errs = append(res.errs, err)
+	}
+	res.Objects = append(res.Objects, el)
+}
+
+func (res *dryRunResult) skip(obj *unstructured.Unstructured, reason string) {
+	res.Objects = append(res.Objects, dryRunObject{
+		Kind:       obj.GetKind(),
+		APIVersion: obj.GetAPIVersion(),
+		Name:       obj.GetName(),
+		Skipped:    reason,
+	})
+}
+
+// dryRunInstall validates the packages and the claim objects against
+// the cluster without persisting them, returning the defaulted objects.
+// The objects are marked as owned as the install does, so that they
+// are defaulted the same way. Objects whose kind is not served yet
+// are skipped.
+func dryRunInstall(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory, pci *packageAndClaimInfo, prm *params) *dryRunResult {
+	ro := resourceOptions{force: prm.force, dryRun: true}
+	res := &dryRunResult{}
+
+	for _, obj := range pci.pkgObjs {
+		out, _, err := applyResourceFromUnstructured(ctx, bus, kf, owned(ctx, obj), ro)
+		res.add(obj, out, err)
+	}
+
+	for _, obj := range pci.objs {
+		gvk := obj.GroupVersionKind()
+		if _, err := kf.RESTMapping(gvk); meta.IsNoMatchError(err) {
+			res.skip(obj, fmt.Sprintf("kind: %s in apiGroup: %s is not served, packages: %s are not installed",
+				gvk.Kind, gvk.Group, objectNames(pci.pkgObjs)))
+			continue
+		}
+
+		out, _, err := applyResourceFromUnstructured(ctx, bus, kf, owned(ctx, obj), ro)
+		res.add(obj, out, err)
+	}
+
+	return res
+}
+
+// dryRunDelete validates the deletion of the claim objects and of the
+// packages without persisting it, returning the objects that would be deleted.
+func dryRunDelete(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory, pci *packageAndClaimInfo, prm *params) *dryRunResult {
+	ro := resourceOptions{dryRun: true}
+	res := &dryRunResult{}
+
+	for _, obj := range deleteOrder(pci, prm.keepPackage) {
+		out, err := deleteResourceFromUnstructured(ctx, bus, kf, obj, ro)
+		if err == nil && out == nil {
+			res.skip(obj, "not found")
+			continue
+		}
+		if meta.IsNoMatchError(err) {
+			res.skip(obj, fmt.Sprintf("kind: %s in apiGroup: %s is not served", obj.GetKind(), obj.GroupVersionKind().Group))
+			continue
+		}
+		res.add(obj, out, err)
+	}
+
+	return res
+}
+
+// writeDryRun replies with the dry-run outcome and its status.
+func writeDryRun(w http.ResponseWriter, res *dryRunResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.status())
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,26 @@
+package modules
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestDryRunStatus(t *testing.T) {
+	obj := newObject(claimGVK, "core", "demo")
+
+	res := &dryRunResult{}
+	res.add(obj, obj, nil)
+	assert.Equal(t, http.StatusOK, res.status())
+
+	res.add(obj, nil, errors.New("connection refused"))
+	assert.Equal(t, http.StatusInternalServerError, res.status())
+
+	invalid := apierrors.NewInvalid(schema.GroupKind{Group: claimGVK.Group, Kind: claimGVK.Kind}, "core", nil)
+	res.add(obj, nil, invalid)
+	assert.Equal(t, http.StatusUnprocessableEntity, res.status())
+}
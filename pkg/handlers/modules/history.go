@@ -0,0 +1,207 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/krateoplatformops/kube-bridge/pkg/history"
+	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/policy"
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var errHistoryDisabled = errors.New("module history is disabled")
+
+// moduleHistory is the list of the revisions of a module, the newest first.
+type moduleHistory struct {
+	Module history.Module      `json:"module"`
+	Items  []*history.Revision `json:"items"`
+}
+
+// History returns the revisions applied to the module identified by the
+// `group`, `kind` and `name` path params (and `namespace` query param).
+func History(opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		m, err := historyModule(r, opts)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), historyErrorStatus(err))
+			return
+		}
+
+		all, err := opts.History.List(m)
+		if err != nil {
+			log.Error().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(&moduleHistory{Module: m, Items: all})
+		if err != nil {
+			log.Error().Msg(err.Error())
+		}
+	})
+}
+
+// Rollback re-applies the packages and the claim of the module revision
+// specified by the `revision` query param, through the same pipeline
+// (and query params) of the `/template` install. The rollback is
+// recorded in the module history as a new revision.
+func Rollback(opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		prm, err := parseParams(r, opts)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		v := r.URL.Query().Get("revision")
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			err = fmt.Errorf("invalid value for 'revision' param: %s", v)
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		m, err := historyModule(r, opts)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), historyErrorStatus(err))
+			return
+		}
+
+		rev, err := opts.History.Get(m, n)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), historyErrorStatus(err))
+			return
+		}
+
+		pci, err := revisionBundle(rev, opts.Policy.Rules())
+		if err == nil {
+			err = checkClaimScope(opts.Clients, pci.clmObj)
+		}
+		if err != nil {
+			log.Error().Msg(err.Error())
+			http.Error(w, err.Error(), decodeErrorStatus(err))
+			return
+		}
+
+		log.Info().
+			Int("revision", rev.Revision).
+			Str("from", rev.DeploymentID).
+			Msg("rolling back module")
+		logBundle(log, pci)
+
+		startInstall(w, r, opts, prm, pci)
+	})
+}
+
+// historyModule returns the module identified by the request,
+// when the history is enabled and the claim kind is allowed.
+func historyModule(r *http.Request, opts Options) (history.Module, error) {
+	params := mux.Vars(r)
+
+	m := history.Module{
+		Group:     params["group"],
+		Kind:      params["kind"],
+		Namespace: r.URL.Query().Get("namespace"),
+		Name:      params["name"],
+	}
+
+	if opts.History == nil {
+		return m, errHistoryDisabled
+	}
+
+	return m, opts.Policy.Rules().AllowClaim(m.GroupKind())
+}
+
+// historyErrorStatus maps the errors of the history endpoints to the reply status.
+func historyErrorStatus(err error) int {
+	var de *policy.DeniedError
+	switch {
+	case errors.As(err, &de):
+		return http.StatusForbidden
+	case errors.Is(err, errHistoryDisabled), errors.Is(err, history.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// revisionBundle returns the objects to install for the revision,
+// checking them against the current policy rules.
+func revisionBundle(rev *history.Revision, rules *policy.Rules) (*packageAndClaimInfo, error) {
+	if rev.Claim == nil {
+		return nil, fmt.Errorf("revision %d has no claim", rev.Revision)
+	}
+
+	res := &packageAndClaimInfo{}
+	for _, el := range rev.Packages {
+		if err := rules.AllowPackage(el.GroupVersionKind().GroupKind()); err != nil {
+			return nil, err
+		}
+		res.pkgObjs = append(res.pkgObjs, el.DeepCopy())
+	}
+
+	gvk := rev.Claim.GroupVersionKind()
+	if err := rules.AllowClaim(gvk.GroupKind()); err != nil {
+		return nil, err
+	}
+
+	res.clmObj, res.clmGVK = rev.Claim.DeepCopy(), &gvk
+	res.objs = []*unstructured.Unstructured{res.clmObj}
+
+	return res, nil
+}
+
+// recordRevision appends the packages and the claim of a successful install
+// to the module history, that is once the claim is Ready (unless the wait
+// is disabled): failed, cancelled and rolled back installs are not offered
+// by `/rollback`. The store keeps the revisions in Secrets, since the
+// claim specs can carry credentials; the supporting objects are not
+// recorded, and are left as they are by a rollback. A failure is
+// logged, without failing the install.
+func recordRevision(ctx context.Context, opts Options, pci *packageAndClaimInfo, prm *params) {
+	if opts.History == nil {
+		return
+	}
+
+	log := zerolog.Ctx(ctx)
+
+	m := history.Module{
+		Group:     pci.clmGVK.Group,
+		Kind:      pci.clmGVK.Kind,
+		Namespace: pci.clmObj.GetNamespace(),
+		Name:      pci.clmObj.GetName(),
+	}
+
+	n, err := opts.History.Record(m, &history.Revision{
+		DeploymentID: middlewares.DeploymentID(ctx),
+		Time:         time.Now().UTC(),
+		Caller:       prm.caller,
+		Packages:     pci.pkgObjs,
+		Claim:        pci.clmObj,
+	})
+	if err != nil {
+		log.Warn().Msgf("recording module history: %s", err.Error())
+		return
+	}
+
+	log.Debug().Int("revision", n).Str("module", m.String()).Msg("module revision recorded")
+}
@@ -0,0 +1,36 @@
+package modules
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/history"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRevisionBundle(t *testing.T) {
+	rev := &history.Revision{
+		Revision: 2,
+		Packages: []*unstructured.Unstructured{newObject(configurationGVK, "krateo-module-core", "")},
+		Claim:    newObject(claimGVK, "core", "demo"),
+	}
+
+	pci, err := revisionBundle(rev, testRules)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "krateo-module-core", objectNames(pci.pkgObjs))
+	assert.Equal(t, "core", objectNames(pci.objs))
+	assert.Equal(t, claimGVK, *pci.clmGVK)
+
+	pci.clmObj.SetName("changed")
+	assert.Equal(t, "core", rev.Claim.GetName())
+
+	providerGVK := schema.GroupVersionKind{Group: "pkg.crossplane.io", Version: "v1", Kind: "Provider"}
+	rev.Packages = append(rev.Packages, newObject(providerGVK, "provider-helm", ""))
+	_, err = revisionBundle(rev, testRules)
+	assert.Equal(t, http.StatusForbidden, historyErrorStatus(err))
+}
@@ -0,0 +1,279 @@
+package modules
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/policy"
+	"github.com/rs/zerolog"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+	moduleTypePackage = "package"
+	moduleTypeClaim   = "claim"
+
+	// labelClaimName is set by Crossplane on the
+	// composites created on behalf of a claim.
+	labelClaimName = "crossplane.io/claim-name"
+
+	defaultListLimit = 100
+	maxListLimit     = 500
+)
+
+// moduleItem is a package or a claim installed by the bridge.
+type moduleItem struct {
+	Type       string `json:"type"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+	// Image is the package OCI image.
+	Image string `json:"image,omitempty"`
+	// Revision is the current package revision.
+	Revision   string                 `json:"revision,omitempty"`
+	Conditions []kubernetes.Condition `json:"conditions,omitempty"`
+	// Ready tells whether a package is Installed and Healthy,
+	// or a claim is Ready and Synced.
+	Ready bool `json:"ready"`
+	// DeploymentID is the `X-Deployment-Id` of
+	// the last operation that applied the object.
+	DeploymentID string `json:"deploymentId,omitempty"`
+}
+
+func (it *moduleItem) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", it.APIVersion, it.Kind, it.Namespace, it.Name)
+}
+
+// moduleList is a page of the modules inventory.
+type moduleList struct {
+	Items []moduleItem `json:"items"`
+	// Continue is the token to fetch the next page, if any.
+	Continue string `json:"continue,omitempty"`
+}
+
+// listParams are the query parameters accepted by the `/modules` endpoint.
+type listParams struct {
+	group    string
+	kind     string
+	selector labels.Selector
+	limit    int
+	// after is the key of the last item of the previous page.
+	after string
+}
+
+// List returns the packages and claims installed by the bridge, that is
+// the objects of the allowed kinds carrying the `kubernetes.LabelOwned` label.
+//
+// The items are sorted by apiVersion, kind, namespace and name,
+// and paginated with the `limit` and `continue` query params.
+func List(opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		prm, err := parseListParams(r)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		all, err := listModules(r.Context(), opts, prm)
+		if err != nil {
+			log.Error().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(paginate(all, prm))
+		if err != nil {
+			log.Error().Msg(err.Error())
+		}
+	})
+}
+
+func parseListParams(r *http.Request) (*listParams, error) {
+	q := r.URL.Query()
+
+	res := &listParams{
+		group:    q.Get("group"),
+		kind:     q.Get("kind"),
+		selector: labels.Everything(),
+		limit:    defaultListLimit,
+	}
+
+	if v := q.Get("labelSelector"); len(v) > 0 {
+		sel, err := labels.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for 'labelSelector' param: %s", err.Error())
+		}
+		res.selector = sel
+	}
+
+	if v := q.Get("limit"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid value for 'limit' param: %s", v)
+		}
+		res.limit = n
+	}
+	if res.limit > maxListLimit {
+		res.limit = maxListLimit
+	}
+
+	if v := q.Get("continue"); len(v) > 0 {
+		dat, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for 'continue' param: %s", v)
+		}
+		res.after = string(dat)
+	}
+
+	return res, nil
+}
+
+// listModules lists the owned objects of every package kind and claim
+// kind allowed by the policy and defined by a CRD. The composites created
+// on behalf of a claim are left out, while the cluster scoped kinds
+// applied as claims (i.e. composites without a claim) are listed.
+func listModules(ctx context.Context, opts Options, prm *listParams) ([]moduleItem, error) {
+	rules := opts.Policy.Rules()
+
+	owned, err := labels.NewRequirement(kubernetes.LabelOwned, selection.Equals, []string{"true"})
+	if err != nil {
+		return nil, err
+	}
+	unclaimed, err := labels.NewRequirement(labelClaimName, selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, err
+	}
+	selector := prm.selector.Add(*owned, *unclaimed).String()
+
+	res := []moduleItem{}
+	for _, crd := range opts.Crds.List() {
+		typ := moduleType(rules, crd)
+		if len(typ) == 0 {
+			continue
+		}
+
+		if len(prm.group) > 0 && crd.Spec.Group != prm.group {
+			continue
+		}
+		if len(prm.kind) > 0 && crd.Spec.Names.Kind != prm.kind {
+			continue
+		}
+
+		version := servedVersion(crd)
+		if len(version) == 0 {
+			continue
+		}
+
+		gvr := schema.GroupVersionResource{Group: crd.Spec.Group, Version: version, Resource: crd.Spec.Names.Plural}
+		all, err := opts.Clients.Dynamic().Resource(gvr).List(ctx, metav1.ListOptions{
+			LabelSelector: selector,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", gvr.GroupResource().String(), err)
+		}
+
+		for i := range all.Items {
+			res = append(res, newModuleItem(typ, &all.Items[i]))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].key() < res[j].key()
+	})
+
+	return res, nil
+}
+
+// moduleType tells whether the CRD defines a package or a claim kind,
+// returning an empty string for any other kind.
+func moduleType(rules *policy.Rules, crd *apiextensionsv1.CustomResourceDefinition) string {
+	gk := schema.GroupKind{Group: crd.Spec.Group, Kind: crd.Spec.Names.Kind}
+
+	if rules.AllowPackage(gk) == nil {
+		return moduleTypePackage
+	}
+
+	if rules.AllowClaim(gk) == nil {
+		return moduleTypeClaim
+	}
+
+	return ""
+}
+
+// servedVersion returns the storage version of the CRD,
+// or the first served one when it is not served.
+func servedVersion(crd *apiextensionsv1.CustomResourceDefinition) string {
+	res := ""
+	for _, el := range crd.Spec.Versions {
+		if !el.Served {
+			continue
+		}
+		if el.Storage {
+			return el.Name
+		}
+		if len(res) == 0 {
+			res = el.Name
+		}
+	}
+	return res
+}
+
+func newModuleItem(typ string, obj *unstructured.Unstructured) moduleItem {
+	res := moduleItem{
+		Type:         typ,
+		APIVersion:   obj.GetAPIVersion(),
+		Kind:         obj.GetKind(),
+		Name:         obj.GetName(),
+		Namespace:    obj.GetNamespace(),
+		Conditions:   kubernetes.Conditions(obj),
+		DeploymentID: obj.GetAnnotations()[kubernetes.AnnotationDeploymentID],
+	}
+
+	ready := allTrue(conditionSynced, conditionReady)
+	if typ == moduleTypePackage {
+		res.Image, _, _ = unstructured.NestedString(obj.Object, "spec", "package")
+		res.Revision, _, _ = unstructured.NestedString(obj.Object, "status", "currentRevision")
+		ready = allTrue(conditionInstalled, conditionHealthy)
+	}
+	res.Ready, _ = ready(obj, res.Conditions)
+
+	return res
+}
+
+// paginate returns the page of at most limit items following
+// the `continue` key, along with the token of the next page.
+func paginate(all []moduleItem, prm *listParams) *moduleList {
+	start := 0
+	if len(prm.after) > 0 {
+		start = sort.Search(len(all), func(i int) bool {
+			return all[i].key() > prm.after
+		})
+	}
+
+	end := start + prm.limit
+	if end >= len(all) {
+		return &moduleList{Items: all[start:]}
+	}
+
+	return &moduleList{
+		Items:    all[start:end],
+		Continue: base64.RawURLEncoding.EncodeToString([]byte(all[end-1].key())),
+	}
+}
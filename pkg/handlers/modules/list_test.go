@@ -0,0 +1,118 @@
+package modules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/policy"
+	"github.com/stretchr/testify/assert"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var configurationGVK = schema.GroupVersionKind{Group: "pkg.crossplane.io", Version: "v1", Kind: "Configuration"}
+
+// fakeCrds serves a static list of CRDs.
+type fakeCrds struct {
+	kubernetes.CrdsWatcher
+	crds []*apiextensionsv1.CustomResourceDefinition
+}
+
+func (f *fakeCrds) List() []*apiextensionsv1.CustomResourceDefinition { return f.crds }
+
+func newCRD(gvk schema.GroupVersionKind, plural string, scope apiextensionsv1.ResourceScope) *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: plural + "." + gvk.Group},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: gvk.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: gvk.Kind, Plural: plural},
+			Scope: scope,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: gvk.Version, Served: true, Storage: true},
+			},
+		},
+	}
+}
+
+func withConditions(obj *unstructured.Unstructured, conds map[string]string) *unstructured.Unstructured {
+	list := []interface{}{}
+	for typ, status := range conds {
+		list = append(list, map[string]interface{}{"type": typ, "status": status})
+	}
+	unstructured.SetNestedSlice(obj.Object, list, "status", "conditions")
+	return obj
+}
+
+func ownedBy(obj *unstructured.Unstructured, id string) *unstructured.Unstructured {
+	obj.SetLabels(map[string]string{kubernetes.LabelOwned: "true", "env": "dev"})
+	obj.SetAnnotations(map[string]string{kubernetes.AnnotationDeploymentID: id})
+	return obj
+}
+
+func TestListModules(t *testing.T) {
+	pkg := ownedBy(withConditions(newObject(configurationGVK, "core", ""),
+		map[string]string{conditionInstalled: "True", conditionHealthy: "True"}), "abc")
+	unstructured.SetNestedField(pkg.Object, "ghcr.io/krateoplatformops/core:0.1.0", "spec", "package")
+	unstructured.SetNestedField(pkg.Object, "core-5c8a9b", "status", "currentRevision")
+
+	clm := ownedBy(withConditions(newObject(claimGVK, "core", "demo"),
+		map[string]string{conditionReady: "True", conditionSynced: "False"}), "def")
+	// composed on behalf of the claim
+	xr := ownedBy(newObject(compositeGVK, "core-x5z7q", ""), "def")
+	xr.SetLabels(map[string]string{kubernetes.LabelOwned: "true", "env": "dev", labelClaimName: "core"})
+	// applied as claim
+	shared := ownedBy(newObject(compositeGVK, "shared", ""), "ghi")
+
+	opts := Options{
+		Clients: newFakeFactory(pkg, clm, newObject(claimGVK, "other", "demo"), xr, shared),
+		Crds: &fakeCrds{crds: []*apiextensionsv1.CustomResourceDefinition{
+			newCRD(configurationGVK, "configurations", apiextensionsv1.ClusterScoped),
+			newCRD(claimGVK, "cores", apiextensionsv1.NamespaceScoped),
+			newCRD(compositeGVK, "xcores", apiextensionsv1.ClusterScoped),
+		}},
+		Policy: policy.Static(testRules),
+	}
+
+	prm := &listParams{selector: mustSelector(t, "env=dev"), limit: 1}
+	all, err := listModules(context.Background(), opts, prm)
+	assert.Nil(t, err)
+	if !assert.Equal(t, 3, len(all)) {
+		return
+	}
+
+	assert.Equal(t, moduleTypeClaim, all[0].Type)
+	assert.Equal(t, "def", all[0].DeploymentID)
+	assert.False(t, all[0].Ready)
+
+	assert.Equal(t, moduleTypeClaim, all[1].Type)
+	assert.Equal(t, "shared", all[1].Name)
+
+	assert.Equal(t, moduleTypePackage, all[2].Type)
+	assert.Equal(t, "ghcr.io/krateoplatformops/core:0.1.0", all[2].Image)
+	assert.Equal(t, "core-5c8a9b", all[2].Revision)
+	assert.True(t, all[2].Ready)
+
+	page := paginate(all, prm)
+	assert.Equal(t, []moduleItem{all[0]}, page.Items)
+	assert.NotEmpty(t, page.Continue)
+
+	prm.after = all[1].key()
+	page = paginate(all, prm)
+	assert.Equal(t, []moduleItem{all[2]}, page.Items)
+	assert.Empty(t, page.Continue)
+
+	prm = &listParams{selector: mustSelector(t, ""), kind: "Configuration"}
+	all, err = listModules(context.Background(), opts, prm)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(all))
+}
+
+func mustSelector(t *testing.T, s string) labels.Selector {
+	sel, err := labels.Parse(s)
+	assert.Nil(t, err)
+	return sel
+}
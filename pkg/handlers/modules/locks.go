@@ -0,0 +1,108 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/support"
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// onConflictQueue runs the operation after the ones
+	// already in progress on the same module.
+	onConflictQueue = "queue"
+	// onConflictReject rejects the operation while another
+	// one on the same module is in progress.
+	onConflictReject = "reject"
+)
+
+// errModuleBusy is returned with `onConflict=reject` when another
+// operation on the same claim or packages is in progress.
+var errModuleBusy = errors.New("another operation on the same module is in progress")
+
+// moduleKeys returns the lock keys of the module: the claim
+// GroupKind, namespace and name, and the name of each package.
+func moduleKeys(pci *packageAndClaimInfo) []string {
+	res := make([]string, 0, len(pci.pkgObjs)+1)
+	res = append(res, fmt.Sprintf("claim:%s/%s/%s",
+		pci.clmGVK.GroupKind().String(), pci.clmObj.GetNamespace(), pci.clmObj.GetName()))
+	for _, el := range pci.pkgObjs {
+		res = append(res, fmt.Sprintf("package:%s", el.GetName()))
+	}
+	return res
+}
+
+// moduleJob is a module operation bound to the module locks.
+type moduleJob struct {
+	keys []string
+	// unlock releases the locks acquired up front with
+	// `onConflict=reject`, nil in queue mode.
+	unlock func()
+	run    func(ctx context.Context) error
+}
+
+// serialize binds the job to the module locks, so that the
+// operations on the same module run one at a time in arrival order.
+//
+// With `onConflict=reject` the locks are acquired right away and errModuleBusy
+// is returned if any of them is held; otherwise they are acquired by dispatch,
+// which waits for them before taking a worker.
+func serialize(opts Options, prm *params, keys []string, job func(ctx context.Context) error) (*moduleJob, error) {
+	res := &moduleJob{keys: keys, run: job}
+	if prm.onConflict != onConflictReject {
+		return res, nil
+	}
+
+	unlock, ok := opts.Locks.TryLock(keys)
+	if !ok {
+		return nil, fmt.Errorf("%w (%s)", errModuleBusy, strings.Join(keys, ", "))
+	}
+	res.unlock = unlock
+
+	return res, nil
+}
+
+// tryLock returns the locks acquired up front,
+// or tries to acquire them without waiting.
+func (mj *moduleJob) tryLock(opts Options) (func(), bool) {
+	if mj.unlock != nil {
+		return mj.unlock, true
+	}
+	return opts.Locks.TryLock(mj.keys)
+}
+
+// queued returns the job waiting for the module locks, publishing a
+// `Queued` notification, and only then running in the reserved slot
+// of the workers pool. The job must not run on a worker itself, since
+// same-module jobs waiting on workers could fill the pool.
+func (mj *moduleJob) queued(opts Options, slot *workers.Reservation, job func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		keys := strings.Join(mj.keys, ", ")
+
+		zerolog.Ctx(ctx).Info().Strs("keys", mj.keys).Msg("operation queued")
+		msg := fmt.Sprintf("Queued after the operations in progress on: %s", keys)
+		opts.Bus.Publish(support.InfoNotification(ctx, support.ReasonQueued, msg))
+
+		unlock, err := opts.Locks.Lock(ctx, mj.keys)
+		if err != nil {
+			slot.Cancel()
+			return fmt.Errorf("waiting for the operations in progress on: %s: %w", keys, err)
+		}
+
+		done := make(chan error, 1)
+		err = slot.Submit(func() {
+			defer unlock()
+			done <- job(ctx)
+		})
+		if err != nil {
+			unlock()
+			return err
+		}
+
+		return <-done
+	}
+}
@@ -0,0 +1,105 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/operations"
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleReleasesLocksWhenRejected(t *testing.T) {
+	pool := workers.New(1, 1)
+	defer pool.Stop(context.Background())
+
+	started, release := make(chan struct{}), make(chan struct{})
+	assert.Nil(t, pool.Submit(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	assert.Nil(t, pool.Submit(func() {}))
+	defer close(release)
+
+	opts := Options{Bus: eventbus.New(), Locks: operations.NewLocks(), Workers: pool}
+	keys := []string{"claim:Core.modules.krateo.io/demo/core"}
+
+	mj, err := serialize(opts, &params{onConflict: onConflictReject}, keys, func(ctx context.Context) error { return nil })
+	assert.Nil(t, err)
+
+	_, start := schedule(opts, mj)
+	err = start(func() {})
+	assert.True(t, errors.Is(err, workers.ErrQueueFull))
+
+	unlock, ok := opts.Locks.TryLock(keys)
+	assert.True(t, ok)
+	unlock()
+}
+
+func TestScheduleQueuedWithoutWorker(t *testing.T) {
+	pool := workers.New(1, 2)
+	defer pool.Stop(context.Background())
+
+	opts := Options{Bus: eventbus.New(), Locks: operations.NewLocks(), Workers: pool}
+	keys := []string{"package:krateo-module-core"}
+
+	unlock, ok := opts.Locks.TryLock(keys)
+	assert.True(t, ok)
+
+	ran := make(chan struct{})
+	mj, err := serialize(opts, &params{onConflict: onConflictQueue}, keys, func(ctx context.Context) error {
+		close(ran)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	job, start := schedule(opts, mj)
+	done := make(chan error, 1)
+	assert.Nil(t, start(func() { done <- job(context.Background()) }))
+
+	// the queued job waits for the locks without taking the worker
+	free := make(chan struct{})
+	assert.Nil(t, pool.Submit(func() { close(free) }))
+	select {
+	case <-free:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker held by the queued job")
+	}
+
+	unlock()
+	<-ran
+	assert.Nil(t, <-done)
+}
+
+func TestScheduleQueuedBounded(t *testing.T) {
+	pool := workers.New(1, 1)
+	defer pool.Stop(context.Background())
+
+	opts := Options{Bus: eventbus.New(), Locks: operations.NewLocks(), Workers: pool}
+	keys := []string{"package:krateo-module-core"}
+
+	unlock, ok := opts.Locks.TryLock(keys)
+	assert.True(t, ok)
+	defer unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nop := func(ctx context.Context) error { return nil }
+
+	mj, err := serialize(opts, &params{onConflict: onConflictQueue}, keys, nop)
+	assert.Nil(t, err)
+	job, start := schedule(opts, mj)
+	assert.Nil(t, start(func() { job(ctx) }))
+
+	// the jobs waiting for the locks take the queue slots
+	mj, err = serialize(opts, &params{onConflict: onConflictQueue}, keys, nop)
+	assert.Nil(t, err)
+	_, start = schedule(opts, mj)
+	assert.True(t, errors.Is(start(func() {}), workers.ErrQueueFull))
+	assert.Equal(t, 1, pool.Stats().Reserved)
+}
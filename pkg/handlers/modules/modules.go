@@ -1,56 +1,391 @@
 package modules
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"sort"
 	"strings"
 
-	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/policy"
+	"github.com/rs/zerolog"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/util/validation"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
 const (
-	moduleConfigurationGroupAndKind = "Configuration.pkg.crossplane.io"
-	moduleClaimsGroupSuffix         = "krateo.io"
+	encodingPlain      = "plain"
+	encodingBase64     = "base64"
+	encodingGzipBase64 = "gzip+base64"
+	encodingJSON       = "json"
+
+	// maxDecodedSize bounds the size of the decompressed payload fields.
+	maxDecodedSize = 8 << 20
 )
 
-func decodeModulePackage(s string) (*unstructured.Unstructured, *schema.GroupVersionKind, error) {
-	data, err := base64.StdEncoding.DecodeString(s)
+// supportingKinds are the kinds that can be shipped along with
+// the claim, listed in the order they are applied. The `*` group
+// matches any group (i.e. the ProviderConfig of every provider);
+// the policy tells which of them are allowed.
+var supportingKinds = []schema.GroupKind{
+	{Group: "", Kind: "Secret"},
+	{Group: "", Kind: "ConfigMap"},
+	{Group: "*", Kind: "ProviderConfig"},
+}
+
+// packageAndClaimInfo is the set of objects carried by a `/template` payload.
+type packageAndClaimInfo struct {
+	// pkgObjs are the CRD-producing packages, installed first.
+	pkgObjs []*unstructured.Unstructured
+	// objs are the supporting objects and the claim in dependency order.
+	objs   []*unstructured.Unstructured
+	clmGVK *schema.GroupVersionKind
+	clmObj *unstructured.Unstructured
+}
+
+// decodeModuleBundle decodes the payload `package` and `claim` fields.
+//
+// Both fields accept multi-document YAML or JSON lists. The `package` field
+// carries only packages; the `claim` field carries exactly one claim, along
+// with its supporting objects and any extra package. Packages, claims and
+// supporting objects not allowed by the rules are reported with a
+// *policy.DeniedError. The supporting objects default to the claim
+// namespace and cannot target any other namespace.
+func decodeModuleBundle(sd *payload, rules *policy.Rules) (*packageAndClaimInfo, error) {
+	data, err := decodeField("package", sd.Package, sd.Encoding)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	obj, gvk, err := decodeUnstructured(data)
+	pkgObjs, err := decodeModulePackages(data, rules)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	if gvk.GroupKind().String() != moduleConfigurationGroupAndKind {
-		return nil, nil, fmt.Errorf("kind: %s in apiGroup: %s is not allowed", gvk.Kind, gvk.Group)
+	data, err = decodeField("claim", sd.Claim, sd.Encoding)
+	if err != nil {
+		return nil, err
 	}
 
-	return obj, gvk, nil
+	objs, err := decodeObjects(data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &packageAndClaimInfo{pkgObjs: pkgObjs}
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		if rules.AllowPackage(gvk.GroupKind()) == nil {
+			res.pkgObjs = append(res.pkgObjs, obj)
+			continue
+		}
+
+		if _, ok := supportingRank(gvk); ok {
+			if err := rules.AllowSupporting(gvk.GroupKind()); err != nil {
+				return nil, err
+			}
+			res.objs = append(res.objs, obj)
+			continue
+		}
+
+		if err := rules.AllowClaim(gvk.GroupKind()); err != nil {
+			return nil, err
+		}
+		if res.clmObj != nil {
+			return nil, fmt.Errorf("only one claim is allowed, found: %s and %s", res.clmObj.GetName(), obj.GetName())
+		}
+		res.clmObj, res.clmGVK = obj, &gvk
+		res.objs = append(res.objs, obj)
+	}
+
+	if res.clmObj == nil {
+		return nil, fmt.Errorf("no claim found (apiGroup: %s)", strings.Join(rules.ClaimGroups, ", "))
+	}
+
+	err = setClaimNamespace(res.clmObj, sd.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// supporting objects default to the claim namespace
+	for _, obj := range res.objs {
+		switch ns := obj.GetNamespace(); {
+		case len(ns) == 0:
+			obj.SetNamespace(res.clmObj.GetNamespace())
+		case ns != res.clmObj.GetNamespace():
+			return nil, fmt.Errorf("kind: %s name: %s namespace: %s differs from the claim namespace: %s",
+				obj.GetKind(), obj.GetName(), ns, res.clmObj.GetNamespace())
+		}
+	}
+
+	sort.SliceStable(res.objs, func(i, j int) bool {
+		return applyRank(res.objs[i]) < applyRank(res.objs[j])
+	})
+
+	return res, nil
 }
 
-func decodeModuleClaim(s string) (*unstructured.Unstructured, *schema.GroupVersionKind, error) {
-	data, err := base64.StdEncoding.DecodeString(s)
+func decodeModulePackages(data []byte, rules *policy.Rules) ([]*unstructured.Unstructured, error) {
+	res, err := decodeObjects(data)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	for _, obj := range res {
+		if err := rules.AllowPackage(obj.GroupVersionKind().GroupKind()); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// supportingRank returns the position of the kind in supportingKinds.
+func supportingRank(gvk schema.GroupVersionKind) (int, bool) {
+	for i, el := range supportingKinds {
+		if el.Kind == gvk.Kind && (el.Group == "*" || el.Group == gvk.Group) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// applyRank sorts the supporting objects before the claim.
+func applyRank(obj *unstructured.Unstructured) int {
+	if i, ok := supportingRank(obj.GroupVersionKind()); ok {
+		return i
+	}
+	return len(supportingKinds)
+}
+
+// decodeErrorStatus replies 403 to the objects denied by the policy.
+func decodeErrorStatus(err error) int {
+	var de *policy.DeniedError
+	if errors.As(err, &de) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// payloadDigest returns the sha256 of the decoded objects, so that the same
+// module sent with different encodings or content types has the same digest.
+func payloadDigest(pci *packageAndClaimInfo) string {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, el := range pci.pkgObjs {
+		enc.Encode(el.Object)
+	}
+	for _, el := range pci.objs {
+		enc.Encode(el.Object)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// objectNames returns the comma separated names of the objects.
+func objectNames(objs []*unstructured.Unstructured) string {
+	res := make([]string, 0, len(objs))
+	for _, el := range objs {
+		res = append(res, el.GetName())
+	}
+	return strings.Join(res, ", ")
+}
+
+func logBundle(log *zerolog.Logger, pci *packageAndClaimInfo) {
+	log.Info().
+		Str("packages", objectNames(pci.pkgObjs)).
+		Str("group", pci.clmGVK.Group).
+		Str("version", pci.clmGVK.Version).
+		Str("kind", pci.clmGVK.Kind).
+		Str("name", pci.clmObj.GetName()).
+		Str("namespace", pci.clmObj.GetNamespace()).
+		Int("objects", len(pci.objs)).
+		Msg("decoded module data")
+}
+
+// setClaimNamespace overrides the claim `metadata.namespace`
+// with the namespace specified in the payload, if any.
+func setClaimNamespace(obj *unstructured.Unstructured, ns string) error {
+	if len(ns) == 0 {
+		return nil
+	}
+
+	if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace: %s: %s", ns, strings.Join(errs, "; "))
+	}
+
+	obj.SetNamespace(ns)
+	return nil
+}
+
+// checkClaimScope rejects a namespaced claim without a namespace.
+// Kinds not served yet (i.e. the package is not installed) are
+// checked later, before the claim is applied.
+func checkClaimScope(kf kubernetes.Factory, obj *unstructured.Unstructured) error {
+	_, err := resourceClient(kf, obj)
+	if errors.Is(err, errNamespaceRequired) {
+		return err
+	}
+	return nil
+}
+
+// decodeField returns the content of a payload field according to the encoding:
+//
+//   - `plain`: a string holding the YAML or JSON documents
+//   - `base64` (default): a string holding the base64 encoded documents
+//   - `gzip+base64`: as `base64`, with the documents compressed by gzip
+//   - `json`: the documents embedded as JSON object or list
+func decodeField(name string, raw json.RawMessage, encoding string) ([]byte, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, fmt.Errorf("missing '%s' field", name)
 	}
 
-	obj, gvk, err := decodeUnstructured(data)
+	if encoding == encodingJSON {
+		if c := bytes.TrimSpace(raw)[0]; c != '{' && c != '[' {
+			return nil, fmt.Errorf("'%s' field must be a JSON object or list with '%s' encoding", name, encodingJSON)
+		}
+		return raw, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("'%s' field must be a string with '%s' encoding", name, encoding)
+	}
+
+	switch encoding {
+	case encodingPlain:
+		return []byte(s), nil
+	case encodingBase64, "":
+		return base64.StdEncoding.DecodeString(s)
+	case encodingGzipBase64:
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return gunzip(data)
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s (expected: %s, %s, %s or %s)",
+			encoding, encodingPlain, encodingBase64, encodingGzipBase64, encodingJSON)
+	}
+}
+
+// gunzip decompresses the data up to maxDecodedSize bytes.
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	defer zr.Close()
+
+	res, err := io.ReadAll(io.LimitReader(zr, maxDecodedSize+1))
+	if err != nil {
+		return nil, err
 	}
+	if len(res) > maxDecodedSize {
+		return nil, fmt.Errorf("decompressed data exceeds %d bytes", maxDecodedSize)
+	}
+	return res, nil
+}
 
-	if g := gvk.GroupKind().Group; !strings.HasSuffix(g, moduleClaimsGroupSuffix) {
-		return nil, nil, fmt.Errorf("apiGroup: %s is not allowed", g)
+// decodeObjects decodes multi-document YAML, JSON lists or `List` objects.
+func decodeObjects(data []byte) ([]*unstructured.Unstructured, error) {
+	docs, err := splitDocuments(data)
+	if err != nil {
+		return nil, err
 	}
 
-	return obj, gvk, nil
+	res := []*unstructured.Unstructured{}
+	for _, doc := range docs {
+		obj, _, err := decodeUnstructured(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		items := []*unstructured.Unstructured{obj}
+		if obj.IsList() {
+			items = items[:0]
+			err = obj.EachListItem(func(el runtime.Object) error {
+				item, ok := el.(*unstructured.Unstructured)
+				if !ok {
+					return fmt.Errorf("unexpected list item: %T", el)
+				}
+				items = append(items, item)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, el := range items {
+			if len(el.GetName()) == 0 {
+				return nil, fmt.Errorf("kind: %s in apiGroup: %s has no name", el.GetKind(), el.GroupVersionKind().Group)
+			}
+		}
+
+		res = append(res, items...)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no objects found")
+	}
+
+	return res, nil
+}
+
+// splitDocuments splits a JSON list or a multi-document YAML
+// in JSON documents, skipping the empty ones.
+func splitDocuments(data []byte) ([][]byte, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+
+		res := make([][]byte, 0, len(items))
+		for _, el := range items {
+			res = append(res, el)
+		}
+		return res, nil
+	}
+
+	res := [][]byte{}
+
+	rd := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := rd.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err = utilyaml.ToJSON(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if s := string(bytes.TrimSpace(doc)); s == "null" || s == "{}" || len(s) == 0 {
+			continue
+		}
+		res = append(res, doc)
+	}
+
+	return res, nil
 }
 
 func decodeUnstructured(data []byte) (*unstructured.Unstructured, *schema.GroupVersionKind, error) {
@@ -64,45 +399,3 @@ func decodeUnstructured(data []byte) (*unstructured.Unstructured, *schema.GroupV
 
 	return obj, gvk, nil
 }
-
-func buildCRDInfo(gvk *schema.GroupVersionKind) *apiextensionsv1.CustomResourceDefinition {
-	return &apiextensionsv1.CustomResourceDefinition{
-		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
-			Group: gvk.Group,
-			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
-				{
-					Name:    gvk.Version,
-					Served:  false,
-					Storage: false,
-				},
-			},
-			Names: apiextensionsv1.CustomResourceDefinitionNames{
-				Kind:   gvk.Kind,
-				Plural: strings.ToLower(gvk.Kind),
-			},
-		},
-	}
-}
-
-func waitForModuleCRDs(rc *rest.Config, gvk *schema.GroupVersionKind) error {
-	return waitForCRDs(rc, []*apiextensionsv1.CustomResourceDefinition{
-		{
-			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
-				Group: gvk.Group,
-
-				Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
-					{
-						Name:    gvk.Version,
-						Served:  false,
-						Storage: false,
-					},
-				},
-				Names: apiextensionsv1.CustomResourceDefinitionNames{
-					Kind: gvk.Kind,
-					//Plural: strings.ToLower(gvk.Kind),
-				},
-			},
-		},
-	},
-	)
-}
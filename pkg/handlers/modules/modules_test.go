@@ -0,0 +1,174 @@
+package modules
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/policy"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	testPackages = `---
+apiVersion: pkg.crossplane.io/v1
+kind: Configuration
+metadata:
+  name: krateo-module-core
+spec:
+  package: ghcr.io/krateoplatformops/krateo-module-core:v1.0.0
+---
+# extra package
+apiVersion: pkg.crossplane.io/v1
+kind: Configuration
+metadata:
+  name: krateo-module-runtime
+spec:
+  package: ghcr.io/krateoplatformops/krateo-module-runtime:v1.0.0
+`
+
+	testClaim = `[
+  {"apiVersion": "modules.krateo.io/v1alpha1", "kind": "Core", "metadata": {"name": "core"}, "spec": {"replicas": 2}},
+  {"apiVersion": "helm.crossplane.io/v1beta1", "kind": "ProviderConfig", "metadata": {"name": "helm"}},
+  {"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "core-credentials"}}
+]`
+)
+
+var testRules = &policy.Rules{
+	Packages:    policy.DefaultPackages,
+	ClaimGroups: policy.DefaultClaimGroups,
+	Supporting:  policy.DefaultSupporting,
+}
+
+func encode(s string) json.RawMessage {
+	return quote(base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+func quote(s string) json.RawMessage {
+	res, _ := json.Marshal(s)
+	return res
+}
+
+func TestDecodeModuleBundle(t *testing.T) {
+	pci, err := decodeModuleBundle(&payload{
+		Package:   encode(testPackages),
+		Claim:     encode(testClaim),
+		Namespace: "demo",
+	}, testRules)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "krateo-module-core, krateo-module-runtime", objectNames(pci.pkgObjs))
+	assert.Equal(t, "core-credentials, helm, core", objectNames(pci.objs))
+	assert.Equal(t, "Core", pci.clmGVK.Kind)
+	assert.Equal(t, "demo", pci.clmObj.GetNamespace())
+	assert.Equal(t, "demo", pci.objs[0].GetNamespace())
+
+	replicas, _, _ := unstructured.NestedInt64(pci.clmObj.Object, "spec", "replicas")
+	assert.Equal(t, int64(2), replicas)
+
+	assert.Equal(t, "core, helm, core-credentials, krateo-module-runtime, krateo-module-core",
+		objectNames(deleteOrder(pci, false)))
+	assert.Equal(t, "core, helm, core-credentials", objectNames(deleteOrder(pci, true)))
+}
+
+func TestDecodeModuleBundleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		pkg    string
+		clm    string
+		status int
+	}{
+		{"claim in package field", testClaim, testClaim, http.StatusForbidden},
+		{"no claim", testPackages, `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "creds"}}`, http.StatusBadRequest},
+		{"kind not allowed", testPackages, `[
+  {"apiVersion": "modules.krateo.io/v1alpha1", "kind": "Core", "metadata": {"name": "core"}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "nginx"}}
+]`, http.StatusForbidden},
+		{"provider not allowed", `{"apiVersion": "pkg.crossplane.io/v1", "kind": "Provider", "metadata": {"name": "provider-helm"}}`,
+			testClaim, http.StatusForbidden},
+		{"two claims", testPackages, `[
+  {"apiVersion": "modules.krateo.io/v1alpha1", "kind": "Core", "metadata": {"name": "core"}},
+  {"apiVersion": "modules.krateo.io/v1alpha1", "kind": "Core", "metadata": {"name": "core-2"}}
+]`, http.StatusBadRequest},
+		{"supporting kind not allowed", testPackages, `[
+  {"apiVersion": "modules.krateo.io/v1alpha1", "kind": "Core", "metadata": {"name": "core"}},
+  {"apiVersion": "aws.crossplane.io/v1beta1", "kind": "ProviderConfig", "metadata": {"name": "aws"}}
+]`, http.StatusForbidden},
+		{"supporting namespace", testPackages, `[
+  {"apiVersion": "modules.krateo.io/v1alpha1", "kind": "Core", "metadata": {"name": "core", "namespace": "demo"}},
+  {"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "creds", "namespace": "kube-system"}}
+]`, http.StatusBadRequest},
+		{"no name", testPackages, `{"apiVersion": "modules.krateo.io/v1alpha1", "kind": "Core"}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeModuleBundle(&payload{Package: encode(tc.pkg), Claim: encode(tc.clm)}, testRules)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.status, decodeErrorStatus(err))
+		})
+	}
+}
+
+func TestDecodeField(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(testPackages))
+	zw.Close()
+
+	tests := []struct {
+		encoding string
+		raw      json.RawMessage
+	}{
+		{"", encode(testPackages)},
+		{encodingBase64, encode(testPackages)},
+		{encodingPlain, quote(testPackages)},
+		{encodingGzipBase64, quote(base64.StdEncoding.EncodeToString(buf.Bytes()))},
+		{encodingJSON, json.RawMessage(`[
+  {"apiVersion": "pkg.crossplane.io/v1", "kind": "Configuration", "metadata": {"name": "krateo-module-core"}},
+  {"apiVersion": "pkg.crossplane.io/v1", "kind": "Configuration", "metadata": {"name": "krateo-module-runtime"}}
+]`)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.encoding, func(t *testing.T) {
+			data, err := decodeField("package", tc.raw, tc.encoding)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			objs, err := decodeModulePackages(data, testRules)
+			assert.Nil(t, err)
+			assert.Equal(t, "krateo-module-core, krateo-module-runtime", objectNames(objs))
+		})
+	}
+
+	_, err := decodeField("package", encode(testPackages), "base32")
+	assert.NotNil(t, err)
+
+	_, err = decodeField("package", encode(testPackages), encodingJSON)
+	assert.NotNil(t, err)
+
+	_, err = decodeField("package", nil, encodingBase64)
+	assert.NotNil(t, err)
+}
+
+func TestPayloadDigest(t *testing.T) {
+	a, err := decodeModuleBundle(&payload{Package: encode(testPackages), Claim: encode(testClaim)}, testRules)
+	assert.Nil(t, err)
+
+	b, err := decodeModuleBundle(&payload{Package: quote(testPackages), Claim: quote(testClaim), Encoding: encodingPlain}, testRules)
+	assert.Nil(t, err)
+
+	c, err := decodeModuleBundle(&payload{Package: encode(testPackages), Claim: encode(testClaim), Namespace: "demo"}, testRules)
+	assert.Nil(t, err)
+
+	assert.Equal(t, payloadDigest(a), payloadDigest(b))
+	assert.NotEqual(t, payloadDigest(a), payloadDigest(c))
+}
@@ -0,0 +1,270 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/operations"
+	"github.com/krateoplatformops/kube-bridge/pkg/support"
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
+	"github.com/rs/zerolog"
+)
+
+// CancelOperation cancels the module operation identified by the `deploymentId`
+// path param; with `rollback=true` an install deletes the objects it created.
+//
+// The reply is 202 as the job stops asynchronously: the operation
+// state turns to `cancelled` once it has done so.
+func CancelOperation(reg operations.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		params := mux.Vars(r)
+
+		rollback, err := boolParam(r.URL.Query(), "rollback")
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		op, err := reg.Cancel(params["deploymentId"], rollback)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+
+			status := http.StatusConflict
+			if errors.Is(err, operations.ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		log.Info().Bool("rollback", rollback).Msg("operation cancellation requested")
+
+		writeAccepted(w, op)
+	})
+}
+
+// GetOperation returns the state and the steps of the
+// module operation identified by the `deploymentId` path param.
+func GetOperation(reg operations.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		params := mux.Vars(r)
+
+		op, ok := reg.Get(params["deploymentId"])
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err := json.NewEncoder(w).Encode(op)
+		if err != nil {
+			log.Error().Msg(err.Error())
+		}
+	})
+}
+
+// writeAccepted replies with 202 pointing the caller to the operation resource.
+func writeAccepted(w http.ResponseWriter, op *operations.Operation) {
+	w.Header().Set("Location", operations.Location(op.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(op)
+}
+
+// writeExisting replies with the status of an operation already started by
+// a previous request with the same `X-Deployment-Id` and payload: 202 while
+// it is still running, 200 with its outcome once finished.
+func writeExisting(w http.ResponseWriter, op *operations.Operation) {
+	if !op.State.Finished() {
+		writeAccepted(w, op)
+		return
+	}
+
+	w.Header().Set("Location", operations.Location(op.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&result{Operation: op})
+}
+
+// result is the outcome of a synchronous operation.
+type result struct {
+	*operations.Operation
+	// Conditions are the final claim status conditions.
+	Conditions []kubernetes.Condition `json:"conditions,omitempty"`
+}
+
+// cancelled tells whether the job context has been
+// cancelled by a `DELETE /operations/{deploymentId}` request.
+func cancelled(ctx context.Context, opts Options) bool {
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		return false
+	}
+
+	op, ok := opts.Registry.Get(middlewares.DeploymentID(ctx))
+	return ok && op.CancelledAt != nil
+}
+
+// notifyCancelled publishes a `Cancelled` notification
+// when the job stops because of a cancellation.
+func notifyCancelled(opts Options, job func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		err := job(ctx)
+		if err == nil || !cancelled(ctx, opts) {
+			return err
+		}
+
+		zerolog.Ctx(ctx).Warn().Msg(err.Error())
+
+		msg := fmt.Sprintf("Operation cancelled: %s", err.Error())
+		opts.Bus.Publish(support.InfoNotification(ctx, support.ReasonCancelled, msg))
+		return err
+	}
+}
+
+// retryAfter is the delay suggested to the callers rejected
+// because the workers queue is full or the service is stopping.
+const retryAfter = "5"
+
+// dispatch runs the job tracked by the operation registry on the workers pool.
+//
+// By default the job runs in background and the caller gets 202 Accepted;
+// with `wait=true` the job is bound to the request and the outcome is
+// returned as soon as it completes or the `timeout` expires.
+//
+// When the workers queue is full the operation is discarded and the
+// caller gets 429 Too Many Requests, while the service is shutting
+// down it gets 503 Service Unavailable. A job queued behind another
+// operation on the same module waits for it without taking a worker,
+// but holding a slot of the workers queue.
+func dispatch(w http.ResponseWriter, r *http.Request, opts Options, prm *params, op *operations.Operation,
+	mj *moduleJob, inspect func(ctx context.Context) []kubernetes.Condition) {
+	job, start := schedule(opts, mj)
+	job = notifyCancelled(opts, job)
+
+	if !prm.wait {
+		err := start(func() {
+			ctx := valueOnlyContext{r.Context()}
+			opts.Registry.Run(ctx, op.ID, job)
+		})
+		if err != nil {
+			writeRejected(w, r, opts, op, err)
+			return
+		}
+
+		writeAccepted(w, op)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), prm.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	err := start(func() {
+		done <- opts.Registry.Run(ctx, op.ID, job)
+	})
+	if err != nil {
+		writeRejected(w, r, opts, op, err)
+		return
+	}
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// still queued, the job fails as soon as a worker picks it up
+		err = ctx.Err()
+	}
+
+	res := &result{}
+	res.Operation, _ = opts.Registry.Get(op.ID)
+	if err == nil && inspect != nil {
+		res.Conditions = inspect(r.Context())
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		var ce *conflictError
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			status = http.StatusGatewayTimeout
+		case res.Operation != nil && res.State == operations.StateCancelled:
+			status = http.StatusConflict
+		case errors.As(err, &ce):
+			status = http.StatusConflict
+		default:
+			status = http.StatusInternalServerError
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// schedule returns the job along with the function starting it: when the
+// module locks are free the job holds them and is submitted to the workers
+// pool (releasing them if rejected), otherwise it reserves a slot of the
+// workers queue, so that it is bounded as the queued jobs, and waits for
+// the locks in its own goroutine before taking the slot.
+func schedule(opts Options, mj *moduleJob) (func(ctx context.Context) error, func(task func()) error) {
+	unlock, ok := mj.tryLock(opts)
+	if !ok {
+		var slot *workers.Reservation
+		job := func(ctx context.Context) error {
+			return mj.queued(opts, slot, mj.run)(ctx)
+		}
+
+		return job, func(task func()) error {
+			var err error
+			slot, err = opts.Workers.Reserve()
+			if err != nil {
+				return err
+			}
+
+			go task()
+			return nil
+		}
+	}
+
+	job := func(ctx context.Context) error {
+		defer unlock()
+		return mj.run(ctx)
+	}
+
+	return job, func(task func()) error {
+		err := opts.Workers.Submit(task)
+		if err != nil {
+			unlock()
+		}
+		return err
+	}
+}
+
+// writeRejected discards the operation that could not be queued and
+// replies with 429 (or 503 when stopping) and a `Retry-After` header.
+func writeRejected(w http.ResponseWriter, r *http.Request, opts Options, op *operations.Operation, err error) {
+	opts.Registry.Discard(op.ID)
+
+	zerolog.Ctx(r.Context()).Warn().
+		Interface("workers", opts.Workers.Stats()).
+		Msg(err.Error())
+
+	status := http.StatusTooManyRequests
+	if errors.Is(err, workers.ErrStopped) {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Retry-After", retryAfter)
+	http.Error(w, err.Error(), status)
+}
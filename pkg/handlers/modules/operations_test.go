@@ -0,0 +1,82 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/operations"
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchWait(t *testing.T) {
+	pool := workers.New(1, 1)
+	defer pool.Stop(context.Background())
+
+	opts := Options{Bus: eventbus.New(), Registry: operations.New(0), Locks: operations.NewLocks(), Workers: pool}
+
+	ready := []kubernetes.Condition{{Type: conditionReady, Status: "True"}}
+	inspect := func(ctx context.Context) []kubernetes.Condition { return ready }
+
+	tests := []struct {
+		name   string
+		job    func(ctx context.Context) error
+		status int
+		state  operations.State
+		conds  []kubernetes.Condition
+	}{
+		{
+			name:   "completed",
+			job:    func(ctx context.Context) error { return nil },
+			status: http.StatusOK,
+			state:  operations.StateSucceeded,
+			conds:  ready,
+		},
+		{
+			name:   "failed",
+			job:    func(ctx context.Context) error { return errors.New("boom") },
+			status: http.StatusInternalServerError,
+			state:  operations.StateFailed,
+		},
+		{
+			name: "timed out",
+			job: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			// the job may still be winding down
+			status: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			op, _, err := opts.Registry.Begin(tc.name, operations.KindInstall, "")
+			assert.Nil(t, err)
+
+			prm := &params{wait: true, timeout: 100 * time.Millisecond, onConflict: onConflictReject}
+			mj, err := serialize(opts, prm, []string{"claim:Core.modules.krateo.io/demo/" + tc.name}, tc.job)
+			assert.Nil(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/modules", nil)
+			rec := httptest.NewRecorder()
+			dispatch(rec, req, opts, prm, op, mj, inspect)
+
+			assert.Equal(t, tc.status, rec.Code)
+
+			res := &result{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(res))
+			if assert.NotNil(t, res.Operation) && tc.state != "" {
+				assert.Equal(t, tc.state, res.State)
+			}
+			assert.Equal(t, tc.conds, res.Conditions)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package modules
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/history"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/operations"
+	"github.com/krateoplatformops/kube-bridge/pkg/policy"
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
+)
+
+const (
+	defaultWaitTimeout = 5 * time.Minute
+)
+
+// Options holds the dependencies shared by the module handlers.
+type Options struct {
+	Clients  kubernetes.Factory
+	Bus      eventbus.Bus
+	Registry operations.Registry
+	Locks    operations.Locks
+	Crds     kubernetes.CrdsWatcher
+	// Workers runs the module operations,
+	// bounding how many are in progress or queued.
+	Workers workers.Pool
+	// Policy tells which packages and claims
+	// the payloads are allowed to carry.
+	Policy policy.Policy
+	// MaxWait is the upper bound of the `timeout`
+	// query param for synchronous requests.
+	MaxWait time.Duration
+	// ReadyTimeout bounds the wait for the claim to become
+	// Ready and Synced after install, zero disables the wait.
+	ReadyTimeout time.Duration
+	// History keeps the specs applied to each module,
+	// nil disables the history and the rollback.
+	History history.Store
+	// MaxBodySize is the max size in bytes of the
+	// payloads, zero means utils.DefaultMaxBodySize.
+	MaxBodySize int64
+}
+
+// params are the query parameters accepted by the `/template` endpoint,
+// along with the caller of the request.
+type params struct {
+	// wait binds the operation to the request
+	// instead of running it in background.
+	wait bool
+	// timeout bounds the synchronous operation.
+	timeout time.Duration
+	// dryRun validates the objects against the cluster
+	// without persisting them and replies synchronously.
+	dryRun bool
+	// force takes the ownership of the fields
+	// conflicting with other field managers.
+	force bool
+	// keepPackage skips the package uninstall on delete,
+	// for when other claims still depend on it.
+	keepPackage bool
+	// waitForCRDs waits on delete for the package CRDs to be removed.
+	waitForCRDs bool
+	// readyTimeout bounds the wait for the claim readiness.
+	readyTimeout time.Duration
+	// onConflict tells whether to queue or reject the operation
+	// while another one on the same module is in progress.
+	onConflict string
+	// caller is who sent the request, recorded in the module history.
+	caller string
+}
+
+func parseParams(r *http.Request, opts Options) (*params, error) {
+	q := r.URL.Query()
+
+	res := &params{
+		timeout:      defaultWaitTimeout,
+		readyTimeout: opts.ReadyTimeout,
+		onConflict:   onConflictQueue,
+		caller:       requestCaller(r),
+	}
+
+	var err error
+	if res.wait, err = boolParam(q, "wait"); err != nil {
+		return nil, err
+	}
+
+	if res.dryRun, err = boolParam(q, "dryRun"); err != nil {
+		return nil, err
+	}
+
+	if res.force, err = boolParam(q, "force"); err != nil {
+		return nil, err
+	}
+
+	if res.keepPackage, err = boolParam(q, "keepPackage"); err != nil {
+		return nil, err
+	}
+
+	if res.waitForCRDs, err = boolParam(q, "waitForCRDs"); err != nil {
+		return nil, err
+	}
+
+	if v := q.Get("onConflict"); len(v) > 0 {
+		if v != onConflictQueue && v != onConflictReject {
+			return nil, fmt.Errorf("invalid value for 'onConflict' param: %s", v)
+		}
+		res.onConflict = v
+	}
+
+	if v := q.Get("timeout"); len(v) > 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid value for 'timeout' param: %s", v)
+		}
+		res.timeout = d
+	}
+
+	if opts.MaxWait > 0 && res.timeout > opts.MaxWait {
+		res.timeout = opts.MaxWait
+	}
+
+	return res, nil
+}
+
+// requestCaller returns the user authenticated by the proxy in front
+// of the bridge, if any, or else the remote address of the request.
+func requestCaller(r *http.Request) string {
+	for _, key := range []string{"X-Forwarded-User", "X-Remote-User"} {
+		if v := r.Header.Get(key); len(v) > 0 {
+			return v
+		}
+	}
+	return r.RemoteAddr
+}
+
+func boolParam(q url.Values, key string) (bool, error) {
+	v := q.Get(key)
+	if len(v) == 0 {
+		return false, nil
+	}
+
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for '%s' param: %s", key, v)
+	}
+	return res, nil
+}
@@ -0,0 +1,131 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/golang/gddo/httputil/header"
+	"github.com/krateoplatformops/kube-bridge/pkg/handlers/utils"
+)
+
+type payload struct {
+	// Claim and Package are strings, or JSON
+	// objects and lists with the `json` encoding.
+	Claim    json.RawMessage `json:"claim"`
+	Package  json.RawMessage `json:"package"`
+	Encoding string          `json:"encoding"`
+	// Namespace overrides the claim `metadata.namespace`.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// decodePayload reads the `/template` payload according to the request content type:
+//
+//   - `application/json` (default): the payload object
+//   - `application/yaml`: a multi-document stream, the first object is
+//     the package and the later ones are the claim objects
+//   - `multipart/form-data`: the `package` and `claim` parts (files or
+//     values) holding plain YAML or JSON, and the optional `namespace`
+//
+// The body is limited to maxBodySize bytes (zero means the default).
+func decodePayload(w http.ResponseWriter, r *http.Request, sd *payload, maxBodySize int64) error {
+	ct, _ := header.ParseValueAndParams(r.Header, "Content-Type")
+
+	maxBodySize = utils.BodyLimit(maxBodySize)
+	switch ct {
+	case "", "application/json":
+		return utils.DecodeJSONBody(w, r, sd, maxBodySize)
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return decodeYAMLPayload(w, r, sd, maxBodySize)
+	case "multipart/form-data":
+		return decodeMultipartPayload(w, r, sd, maxBodySize)
+	default:
+		msg := fmt.Sprintf("Content-Type header %s is not supported", ct)
+		return &utils.MalformedRequest{Status: http.StatusUnsupportedMediaType, Msg: msg}
+	}
+}
+
+func decodeYAMLPayload(w http.ResponseWriter, r *http.Request, sd *payload, maxBodySize int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		if utils.IsBodyTooLarge(err) {
+			return utils.BodyTooLarge(maxBodySize)
+		}
+		return err
+	}
+
+	docs, err := splitDocuments(data)
+	if err != nil {
+		msg := fmt.Sprintf("Request body contains badly-formed YAML: %s", err.Error())
+		return &utils.MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
+	}
+
+	if len(docs) < 2 {
+		msg := "Request body must contain the package followed by the claim"
+		return &utils.MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
+	}
+
+	sd.Encoding = encodingJSON
+	sd.Package = docs[0]
+	sd.Claim = append(append([]byte("["), bytes.Join(docs[1:], []byte(","))...), ']')
+
+	return nil
+}
+
+func decodeMultipartPayload(w http.ResponseWriter, r *http.Request, sd *payload, maxBodySize int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	err := r.ParseMultipartForm(maxBodySize)
+	if err != nil {
+		if utils.IsBodyTooLarge(err) {
+			return utils.BodyTooLarge(maxBodySize)
+		}
+		msg := fmt.Sprintf("Request body contains badly-formed multipart form: %s", err.Error())
+		return &utils.MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	sd.Encoding = encodingPlain
+	if vals := r.MultipartForm.Value["namespace"]; len(vals) > 0 {
+		sd.Namespace = vals[0]
+	}
+	if sd.Package, err = formPart(r, "package"); err != nil {
+		return err
+	}
+	if sd.Claim, err = formPart(r, "claim"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// formPart returns the content of the named file part, or of the named
+// value when no file is attached, as JSON string.
+func formPart(r *http.Request, name string) (json.RawMessage, error) {
+	var data []byte
+	if fhs := r.MultipartForm.File[name]; len(fhs) > 0 {
+		f, err := fhs[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if data, err = io.ReadAll(f); err != nil {
+			return nil, err
+		}
+	} else if vals := r.MultipartForm.Value[name]; len(vals) > 0 {
+		data = []byte(vals[0])
+	}
+
+	if len(strings.TrimSpace(string(data))) == 0 {
+		msg := fmt.Sprintf("Request body must contain the '%s' part", name)
+		return nil, &utils.MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
+	}
+
+	return json.Marshal(string(data))
+}
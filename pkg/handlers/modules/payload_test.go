@@ -0,0 +1,90 @@
+package modules
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/handlers/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const testClaimYAML = `apiVersion: modules.krateo.io/v1alpha1
+kind: Core
+metadata:
+  name: core
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: core-credentials
+`
+
+func TestDecodeYAMLPayload(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/template", strings.NewReader(testPackages+"---\n"+testClaimYAML))
+	req.Header.Set("Content-Type", "application/yaml")
+
+	var sd payload
+	err := decodePayload(httptest.NewRecorder(), req, &sd, 0)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	pci, err := decodeModuleBundle(&sd, testRules)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the second package is a later object, so it is moved among the packages
+	assert.Equal(t, "krateo-module-core, krateo-module-runtime", objectNames(pci.pkgObjs))
+	assert.Equal(t, "core-credentials, core", objectNames(pci.objs))
+}
+
+func TestDecodeMultipartPayload(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("package", "package.yaml")
+	fw.Write([]byte(testPackages))
+	fw, _ = mw.CreateFormFile("claim", "claim.yaml")
+	fw.Write([]byte(testClaimYAML))
+	mw.WriteField("namespace", "demo")
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/template", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var sd payload
+	err := decodePayload(httptest.NewRecorder(), req, &sd, 0)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	pci, err := decodeModuleBundle(&sd, testRules)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "krateo-module-core, krateo-module-runtime", objectNames(pci.pkgObjs))
+	assert.Equal(t, "demo", pci.clmObj.GetNamespace())
+}
+
+func TestDecodePayloadErrors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/template", strings.NewReader("claim"))
+	req.Header.Set("Content-Type", "text/plain")
+
+	var mr *utils.MalformedRequest
+	err := decodePayload(httptest.NewRecorder(), req, &payload{}, 0)
+	assert.True(t, errors.As(err, &mr))
+	assert.Equal(t, http.StatusUnsupportedMediaType, mr.Status)
+
+	req = httptest.NewRequest(http.MethodPost, "/template", strings.NewReader(testPackages+"---\n"+testClaimYAML))
+	req.Header.Set("Content-Type", "application/yaml")
+
+	err = decodePayload(httptest.NewRecorder(), req, &payload{}, 64)
+	assert.True(t, errors.As(err, &mr))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, mr.Status)
+}
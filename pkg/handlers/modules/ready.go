@@ -0,0 +1,236 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/support"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+const (
+	conditionReady     = "Ready"
+	conditionSynced    = "Synced"
+	conditionInstalled = "Installed"
+	conditionHealthy   = "Healthy"
+
+	reasonUnhealthyPackageRevision = "UnhealthyPackageRevision"
+)
+
+// conditionsCheck tells if the watched object reached the desired state.
+// Returning an error stops the watch immediately.
+type conditionsCheck func(obj *unstructured.Unstructured, conds []kubernetes.Condition) (bool, error)
+
+// watchConditions watches the object until check is satisfied or the timeout
+// expires, publishing a notification for each transition of the specified
+// condition types. On timeout the last seen conditions are reported.
+func watchConditions(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory,
+	obj *unstructured.Unstructured, timeout time.Duration, types []string, check conditionsCheck) error {
+	log := zerolog.Ctx(ctx)
+
+	gvk := obj.GroupVersionKind()
+	cli, err := resourceClient(kf, obj)
+	if err != nil {
+		return err
+	}
+
+	fs := fields.OneTermEqualSelector("metadata.name", obj.GetName()).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = fs
+			return cli.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = fs
+			return cli.Watch(ctx, opts)
+		},
+	}
+
+	ctx, cancel := watchtools.ContextWithOptionalTimeout(ctx, timeout)
+	defer cancel()
+
+	last := map[string]kubernetes.Condition{}
+	_, err = watchtools.UntilWithSync(ctx, lw, &unstructured.Unstructured{}, nil,
+		func(evt watch.Event) (bool, error) {
+			if evt.Type == watch.Deleted {
+				return false, fmt.Errorf("%s: %s has been deleted", gvk.Kind, obj.GetName())
+			}
+
+			cur, ok := evt.Object.(*unstructured.Unstructured)
+			if !ok {
+				return false, nil
+			}
+
+			conds := kubernetes.Conditions(cur)
+			for _, typ := range types {
+				c := kubernetes.FindCondition(conds, typ)
+				if c == nil {
+					continue
+				}
+
+				if prev, ok := last[typ]; ok && prev.Status == c.Status && prev.Reason == c.Reason {
+					continue
+				}
+				last[typ] = *c
+
+				log.Info().
+					Str("kind", gvk.Kind).
+					Str("name", obj.GetName()).
+					Str("type", c.Type).
+					Str("status", c.Status).
+					Str("reason", c.Reason).
+					Msg("condition changed")
+
+				msg := fmt.Sprintf("Condition changed (kind: %s, name: %s, type: %s, status: %s, reason: %s)",
+					gvk.Kind, obj.GetName(), c.Type, c.Status, c.Reason)
+				if len(c.Message) > 0 {
+					msg = fmt.Sprintf("%s: %s", msg, c.Message)
+				}
+				bus.Publish(support.InfoNotification(ctx, support.ReasonConditionChanged, msg).WithResource(cur))
+			}
+
+			return check(cur, conds)
+		})
+	if err = cancelledErr(ctx, err); errors.Is(err, wait.ErrWaitTimeout) {
+		return fmt.Errorf("%s: %s not ready after %s: %s", gvk.Kind, obj.GetName(), timeout, describeConditions(types, last))
+	}
+
+	return err
+}
+
+// allTrue returns a check satisfied when all the condition types are True.
+func allTrue(types ...string) conditionsCheck {
+	return func(_ *unstructured.Unstructured, conds []kubernetes.Condition) (bool, error) {
+		for _, typ := range types {
+			c := kubernetes.FindCondition(conds, typ)
+			if c == nil || c.Status != string(metav1.ConditionTrue) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// waitForClaimReady waits for the claim `Ready` and `Synced` conditions to become True.
+func waitForClaimReady(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory, obj *unstructured.Unstructured, timeout time.Duration) error {
+	types := []string{conditionSynced, conditionReady}
+	return watchConditions(ctx, bus, kf, obj, timeout, types, allTrue(types...))
+}
+
+// waitForPackageHealthy waits for the package `Installed` and `Healthy` conditions
+// to become True, following its current revision: as soon as the package or the
+// revision reports unhealthy (i.e. image pull failure, unresolved dependency) the
+// wait stops with the package manager error.
+func waitForPackageHealthy(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory, obj *unstructured.Unstructured, timeout time.Duration) error {
+	log := zerolog.Ctx(ctx)
+
+	types := []string{conditionInstalled, conditionHealthy}
+	ready := allTrue(types...)
+
+	var currentRevision string
+	check := func(cur *unstructured.Unstructured, conds []kubernetes.Condition) (bool, error) {
+		if err := packageFailed(cur, conds); err != nil {
+			return false, err
+		}
+
+		rev, _, _ := unstructured.NestedString(cur.Object, "status", "currentRevision")
+		if len(rev) == 0 {
+			return false, nil
+		}
+
+		if rev != currentRevision {
+			currentRevision = rev
+			log.Info().
+				Str("kind", cur.GetKind()).
+				Str("name", cur.GetName()).
+				Str("revision", rev).
+				Msg("package revision changed")
+
+			msg := fmt.Sprintf("Package revision changed (kind: %s, name: %s, revision: %s)", cur.GetKind(), cur.GetName(), rev)
+			bus.Publish(support.InfoNotification(ctx, support.ReasonRevisionChanged, msg).WithResource(cur))
+		}
+
+		if ok, _ := ready(cur, conds); ok {
+			return true, nil
+		}
+
+		hc, err := packageRevisionCondition(ctx, kf, cur, rev)
+		if err != nil || hc == nil {
+			return false, nil //nolint:nilerr
+		}
+
+		if hc.Status == string(metav1.ConditionFalse) && hc.Reason == reasonUnhealthyPackageRevision {
+			return false, fmt.Errorf("%s: %s revision: %s is unhealthy: %s", cur.GetKind(), cur.GetName(), rev, hc.Message)
+		}
+
+		return false, nil
+	}
+
+	return watchConditions(ctx, bus, kf, obj, timeout, types, check)
+}
+
+// packageFailed returns the error reported by the package own `Installed`
+// or `Healthy` condition, that is False along with a message (i.e. the
+// image cannot be fetched before any revision is created).
+func packageFailed(pkg *unstructured.Unstructured, conds []kubernetes.Condition) error {
+	for _, typ := range []string{conditionInstalled, conditionHealthy} {
+		c := kubernetes.FindCondition(conds, typ)
+		if c == nil || c.Status != string(metav1.ConditionFalse) || len(c.Message) == 0 {
+			continue
+		}
+
+		return fmt.Errorf("%s: %s is not %s (reason: %s): %s", pkg.GetKind(), pkg.GetName(), typ, c.Reason, c.Message)
+	}
+
+	return nil
+}
+
+// packageRevisionCondition returns the `Healthy` condition of the
+// package revision (i.e. ConfigurationRevision for a Configuration).
+func packageRevisionCondition(ctx context.Context, kf kubernetes.Factory, pkg *unstructured.Unstructured, name string) (*kubernetes.Condition, error) {
+	ref := &unstructured.Unstructured{}
+	ref.SetAPIVersion(pkg.GetAPIVersion())
+	ref.SetKind(pkg.GetKind() + "Revision")
+	ref.SetName(name)
+
+	rev, err := getResource(ctx, kf, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.FindCondition(kubernetes.Conditions(rev), conditionHealthy), nil
+}
+
+func describeConditions(types []string, conds map[string]kubernetes.Condition) string {
+	res := make([]string, 0, len(types))
+	for _, typ := range types {
+		c, ok := conds[typ]
+		if !ok {
+			res = append(res, fmt.Sprintf("%s=Unknown", typ))
+			continue
+		}
+
+		s := fmt.Sprintf("%s=%s", c.Type, c.Status)
+		if len(c.Reason) > 0 {
+			s = fmt.Sprintf("%s (%s)", s, c.Reason)
+		}
+		if len(c.Message) > 0 {
+			s = fmt.Sprintf("%s: %s", s, c.Message)
+		}
+		res = append(res, s)
+	}
+	return strings.Join(res, "; ")
+}
@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPackageFailed(t *testing.T) {
+	pkg := newObject(configurationGVK, "krateo-module-core", "")
+
+	unpacking := []kubernetes.Condition{{Type: conditionInstalled, Status: "False", Reason: "UnpackPackage"}}
+	assert.Nil(t, packageFailed(pkg, unpacking))
+
+	failed := []kubernetes.Condition{
+		{Type: conditionInstalled, Status: "False", Reason: "UnpackPackage",
+			Message: "cannot unpack package: failed to fetch package digest from remote"},
+	}
+	err := packageFailed(pkg, failed)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "is not Installed (reason: UnpackPackage): cannot unpack package")
+	}
+
+	healthy := []kubernetes.Condition{
+		{Type: conditionInstalled, Status: "True", Reason: "ActivePackageRevision"},
+		{Type: conditionHealthy, Status: "True", Reason: "HealthyPackageRevision"},
+	}
+	assert.Nil(t, packageFailed(pkg, healthy))
+}
+
+func TestWaitForClaimReady(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ready", func(t *testing.T) {
+		clm := withConditions(newObject(claimGVK, "core", "demo"),
+			map[string]string{conditionReady: "False", conditionSynced: "True"})
+		kf := newFakeFactory(clm)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			upd := withConditions(clm.DeepCopy(), map[string]string{conditionReady: "True", conditionSynced: "True"})
+			if cli, err := resourceClient(kf, upd); err == nil {
+				cli.Update(ctx, upd, metav1.UpdateOptions{})
+			}
+		}()
+
+		assert.Nil(t, waitForClaimReady(ctx, eventbus.New(), kf, clm, 5*time.Second))
+	})
+
+	t.Run("failed condition", func(t *testing.T) {
+		clm := newObject(claimGVK, "core", "demo")
+		unstructured.SetNestedSlice(clm.Object, []interface{}{
+			map[string]interface{}{"type": conditionSynced, "status": "True"},
+			map[string]interface{}{"type": conditionReady, "status": "False", "reason": "Creating",
+				"message": "cannot compose resources: no matching composition"},
+		}, "status", "conditions")
+
+		err := waitForClaimReady(ctx, eventbus.New(), newFakeFactory(clm), clm, 100*time.Millisecond)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "Ready=False (Creating): cannot compose resources")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		clm := newObject(claimGVK, "core", "demo")
+
+		err := waitForClaimReady(ctx, eventbus.New(), newFakeFactory(clm), clm, 100*time.Millisecond)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "Core: core not ready after 100ms: Synced=Unknown; Ready=Unknown")
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// resourceRef returns the composite referenced
+// by the `spec.resourceRef` of a claim, if any.
+func resourceRef(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	ref, found, err := unstructured.NestedMap(obj.Object, "spec", "resourceRef")
+	if err != nil || !found {
+		return nil
+	}
+
+	return objectFromRef(ref)
+}
+
+// resourceRefs returns the composed resources referenced
+// by the `spec.resourceRefs` of a composite.
+func resourceRefs(obj *unstructured.Unstructured) []*unstructured.Unstructured {
+	refs, found, err := unstructured.NestedSlice(obj.Object, "spec", "resourceRefs")
+	if err != nil || !found {
+		return nil
+	}
+
+	res := make([]*unstructured.Unstructured, 0, len(refs))
+	for _, el := range refs {
+		ref, ok := el.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if o := objectFromRef(ref); o != nil {
+			res = append(res, o)
+		}
+	}
+
+	return res
+}
+
+// composedObjects returns the referenced object and the ones it composes,
+// following the `spec.resourceRefs` recursively up to maxTreeDepth levels
+// (as composedTree does). The objects that cannot be fetched are
+// returned, but not followed.
+func composedObjects(ctx context.Context, kf kubernetes.Factory, ref *unstructured.Unstructured, depth int, seen map[string]bool) []*unstructured.Unstructured {
+	key := fmt.Sprintf("%s/%s/%s/%s", ref.GetAPIVersion(), ref.GetKind(), ref.GetNamespace(), ref.GetName())
+	if seen[key] {
+		return nil
+	}
+	seen[key] = true
+
+	res := []*unstructured.Unstructured{ref}
+	if depth >= maxTreeDepth {
+		return res
+	}
+
+	obj, err := getResource(ctx, kf, ref)
+	if err != nil {
+		return res
+	}
+
+	for _, el := range resourceRefs(obj) {
+		res = append(res, composedObjects(ctx, kf, el, depth+1, seen)...)
+	}
+
+	return res
+}
+
+func objectFromRef(ref map[string]interface{}) *unstructured.Unstructured {
+	apiVersion, _ := ref["apiVersion"].(string)
+	kind, _ := ref["kind"].(string)
+	name, _ := ref["name"].(string)
+	if len(apiVersion) == 0 || len(kind) == 0 || len(name) == 0 {
+		return nil
+	}
+
+	res := &unstructured.Unstructured{}
+	res.SetAPIVersion(apiVersion)
+	res.SetKind(kind)
+	res.SetName(name)
+	if ns, ok := ref["namespace"].(string); ok {
+		res.SetNamespace(ns)
+	}
+
+	return res
+}
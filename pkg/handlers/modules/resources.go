@@ -2,25 +2,24 @@ package modules
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
 	"github.com/krateoplatformops/kube-bridge/pkg/support"
 	"github.com/rs/zerolog"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
-	"k8s.io/client-go/discovery"
-	memory "k8s.io/client-go/discovery/cached"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/restmapper"
 )
 
-func createResourceFromYAML(ctx context.Context, bus eventbus.Bus, rc *rest.Config, dc dynamic.Interface, src []byte) error {
+func createResourceFromYAML(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory, src []byte) error {
 	obj := &unstructured.Unstructured{}
 
 	// decode YAML into unstructured.Unstructured
@@ -30,84 +29,143 @@ func createResourceFromYAML(ctx context.Context, bus eventbus.Bus, rc *rest.Conf
 		return err
 	}
 
-	return createOrUpdateResourceFromUnstructured(ctx, bus, rc, dc, obj)
+	_, _, err = applyResourceFromUnstructured(ctx, bus, kf, obj, resourceOptions{})
+	return err
+}
+
+// errNamespaceRequired is returned for a namespaced
+// object that does not specify its namespace.
+var errNamespaceRequired = errors.New("namespace is required")
+
+// resourceOptions tunes the writes of the module resources.
+type resourceOptions struct {
+	// force takes the ownership of the fields
+	// conflicting with other field managers.
+	force bool
+	// dryRun sends the request with `dryRun=All`: the
+	// object is validated and defaulted but not persisted.
+	dryRun bool
 }
 
-func createOrUpdateResourceFromUnstructured(ctx context.Context, bus eventbus.Bus, rc *rest.Config, dc dynamic.Interface, obj *unstructured.Unstructured) error {
+func (o resourceOptions) dryRunValues() []string {
+	if o.dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// owned returns a copy of the object labelled as applied by the bridge
+// and annotated with the deployment id of the current operation.
+func owned(ctx context.Context, obj *unstructured.Unstructured) *unstructured.Unstructured {
+	res := obj.DeepCopy()
+
+	labels := res.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[kubernetes.LabelOwned] = "true"
+	res.SetLabels(labels)
+
+	if id := middlewares.DeploymentID(ctx); len(id) > 0 {
+		annotations := res.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[kubernetes.AnnotationDeploymentID] = id
+		res.SetAnnotations(annotations)
+	}
+
+	return res
+}
+
+// applyResourceFromUnstructured creates or updates the object using
+// server-side apply with the `kubernetes.DefaultFieldManager` manager,
+// created tells whether the object did not exist before.
+func applyResourceFromUnstructured(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory, obj *unstructured.Unstructured, opts resourceOptions) (res *unstructured.Unstructured, created bool, err error) {
 	log := zerolog.Ctx(ctx)
 
 	gvk := obj.GroupVersionKind()
 
-	mapping, err := findGVR(&gvk, rc)
+	cli, err := resourceClient(kf, obj)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	cli := dc.Resource(mapping.Resource)
+	// only used to tell creations from updates
+	_, err = cli.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, false, err
+	}
+	exists := err == nil
 
-	res, err := cli.Get(ctx, obj.GetName(), metav1.GetOptions{})
-	if err == nil {
-		obj.SetResourceVersion(res.GetResourceVersion())
-		_, err = cli.Update(context.Background(), obj, metav1.UpdateOptions{})
-		if err == nil {
-			log.Info().
-				Str("group", gvk.Group).
-				Str("version", gvk.Version).
-				Str("kind", gvk.Kind).
-				Str("name", obj.GetName()).
-				Msg("resource successfully updated")
-
-			msg := fmt.Sprintf("Resource successfully updated (apiGroup: %s, kind: %s)", gvk.Group, gvk.Kind)
-			bus.Publish(support.InfoNotification(ctx, support.ReasonResourceUpdated, msg))
-		}
-		return err
-	} else {
-		if !errors.IsNotFound(err) {
-			return err
+	obj = obj.DeepCopy()
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, false, err
+	}
+
+	res, err = cli.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: kubernetes.DefaultFieldManager,
+		Force:        &opts.force,
+		DryRun:       opts.dryRunValues(),
+	})
+	if err != nil {
+		if apierrors.IsConflict(err) {
+			return nil, false, newConflictError(obj, err)
 		}
+		return nil, false, err
 	}
 
-	_, err = cli.Create(ctx, obj, metav1.CreateOptions{})
-	if err == nil {
-		if err == nil {
-			log.Info().
-				Str("group", gvk.Group).
-				Str("version", gvk.Version).
-				Str("kind", gvk.Kind).
-				Str("name", obj.GetName()).
-				Msg("resource successfully created")
+	if opts.dryRun {
+		return res, !exists, nil
+	}
 
-			msg := fmt.Sprintf("Resource successfully created (apiGroup: %s, kind: %s)", gvk.Group, gvk.Kind)
-			bus.Publish(support.InfoNotification(ctx, support.ReasonResourceCreated, msg))
-		}
+	reason, action := support.ReasonResourceCreated, "created"
+	if exists {
+		reason, action = support.ReasonResourceUpdated, "updated"
 	}
-	return err
 
+	log.Info().
+		Str("group", gvk.Group).
+		Str("version", gvk.Version).
+		Str("kind", gvk.Kind).
+		Str("name", obj.GetName()).
+		Msgf("resource successfully %s", action)
+
+	msg := fmt.Sprintf("Resource successfully %s (apiGroup: %s, kind: %s)", action, gvk.Group, gvk.Kind)
+	bus.Publish(support.InfoNotification(ctx, reason, msg).WithResource(obj))
+
+	return res, !exists, nil
 }
 
-func deleteResourceFromUnstructured(ctx context.Context, bus eventbus.Bus, rc *rest.Config, dc dynamic.Interface, obj *unstructured.Unstructured) error {
+// deleteResourceFromUnstructured deletes the object returning
+// its live state, or nil if the object was not found.
+func deleteResourceFromUnstructured(ctx context.Context, bus eventbus.Bus, kf kubernetes.Factory, obj *unstructured.Unstructured, opts resourceOptions) (*unstructured.Unstructured, error) {
 	log := zerolog.Ctx(ctx)
 
 	gvk := obj.GroupVersionKind()
 
-	mapping, err := findGVR(&gvk, rc)
+	cli, err := resourceClient(kf, obj)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cli := dc.Resource(mapping.Resource)
-
 	res, err := cli.Get(ctx, obj.GetName(), metav1.GetOptions{})
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return nil
+		if apierrors.IsNotFound(err) {
+			return nil, nil
 		}
 
-		return err
+		return nil, err
 	}
 
-	err = cli.Delete(ctx, res.GetName(), metav1.DeleteOptions{})
-	if err == nil {
+	err = cli.Delete(ctx, res.GetName(), metav1.DeleteOptions{
+		DryRun: opts.dryRunValues(),
+	})
+	if err == nil && !opts.dryRun {
 		log.Info().
 			Str("group", gvk.Group).
 			Str("version", gvk.Version).
@@ -116,19 +174,42 @@ func deleteResourceFromUnstructured(ctx context.Context, bus eventbus.Bus, rc *r
 			Msg("resource successfully deleted")
 
 		msg := fmt.Sprintf("Resource successfully deleted (apiGroup: %s, kind: %s)", gvk.Group, gvk.Kind)
-		bus.Publish(support.InfoNotification(ctx, support.ReasonResourceCreated, msg))
+		bus.Publish(support.InfoNotification(ctx, support.ReasonResourceDeleted, msg).WithResource(obj))
 	}
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
-// find the corresponding GVR (available in *meta.RESTMapping) for gvk
-func findGVR(gvk *schema.GroupVersionKind, cfg *rest.Config) (*meta.RESTMapping, error) {
-	// DiscoveryClient queries API server about the resources
-	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+// getResource fetches the live state of the specified object.
+func getResource(ctx context.Context, kf kubernetes.Factory, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	cli, err := resourceClient(kf, obj)
 	if err != nil {
 		return nil, err
 	}
-	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
 
-	return mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	return cli.Get(ctx, obj.GetName(), metav1.GetOptions{})
+}
+
+// resourceClient returns the client for the object resource, bound to
+// the object namespace when the kind is namespaced.
+func resourceClient(kf kubernetes.Factory, obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+
+	mapping, err := kf.RESTMapping(gvk)
+	if err != nil {
+		return nil, err
+	}
+
+	cli := kf.Dynamic().Resource(mapping.Resource)
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return cli, nil
+	}
+
+	if len(obj.GetNamespace()) == 0 {
+		return nil, fmt.Errorf("%w: kind: %s in apiGroup: %s is namespaced", errNamespaceRequired, gvk.Kind, gvk.Group)
+	}
+
+	return cli.Namespace(obj.GetNamespace()), nil
 }
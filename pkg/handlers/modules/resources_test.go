@@ -0,0 +1,80 @@
+package modules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	claimGVK     = schema.GroupVersionKind{Group: "modules.krateo.io", Version: "v1alpha1", Kind: "Core"}
+	compositeGVK = schema.GroupVersionKind{Group: "modules.krateo.io", Version: "v1alpha1", Kind: "XCore"}
+)
+
+// fakeFactory serves a static mapping and a fake dynamic client.
+type fakeFactory struct {
+	mapper  meta.RESTMapper
+	dynamic dynamic.Interface
+}
+
+func newFakeFactory(objs ...runtime.Object) *fakeFactory {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(claimGVK, meta.RESTScopeNamespace)
+	mapper.Add(compositeGVK, meta.RESTScopeRoot)
+
+	return &fakeFactory{
+		mapper:  mapper,
+		dynamic: fake.NewSimpleDynamicClient(runtime.NewScheme(), objs...),
+	}
+}
+
+func (f *fakeFactory) RESTConfig() *rest.Config          { return &rest.Config{} }
+func (f *fakeFactory) Dynamic() dynamic.Interface        { return f.dynamic }
+func (f *fakeFactory) Secrets() kubernetes.SecretsClient { return nil }
+
+func (f *fakeFactory) RESTMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	return f.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+func newObject(gvk schema.GroupVersionKind, name, namespace string) *unstructured.Unstructured {
+	res := &unstructured.Unstructured{}
+	res.SetGroupVersionKind(gvk)
+	res.SetName(name)
+	res.SetNamespace(namespace)
+	return res
+}
+
+func TestResourceClientScope(t *testing.T) {
+	kf := newFakeFactory()
+
+	_, err := resourceClient(kf, newObject(claimGVK, "core", ""))
+	assert.True(t, errors.Is(err, errNamespaceRequired))
+
+	_, err = resourceClient(kf, newObject(claimGVK, "core", "demo"))
+	assert.Nil(t, err)
+
+	_, err = resourceClient(kf, newObject(compositeGVK, "core-x5z7q", ""))
+	assert.Nil(t, err)
+}
+
+func TestSetClaimNamespace(t *testing.T) {
+	obj := newObject(claimGVK, "core", "default")
+
+	assert.Nil(t, setClaimNamespace(obj, ""))
+	assert.Equal(t, "default", obj.GetNamespace())
+
+	assert.Nil(t, setClaimNamespace(obj, "demo"))
+	assert.Equal(t, "demo", obj.GetNamespace())
+
+	assert.NotNil(t, setClaimNamespace(obj, "Not_Valid"))
+	assert.Equal(t, "demo", obj.GetNamespace())
+}
@@ -0,0 +1,150 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/rs/zerolog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// maxTreeDepth bounds the traversal of nested composites.
+const maxTreeDepth = 10
+
+// treeNode is an object of the claim composite tree.
+type treeNode struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Name       string                `json:"name"`
+	Namespace  string                `json:"namespace,omitempty"`
+	Ready      *kubernetes.Condition `json:"ready,omitempty"`
+	Synced     *kubernetes.Condition `json:"synced,omitempty"`
+	// Error is the message of the first condition not True,
+	// or why the object could not be fetched.
+	Error    string      `json:"error,omitempty"`
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+// moduleStatus is the live claim along with its composite tree.
+type moduleStatus struct {
+	Claim *unstructured.Unstructured `json:"claim"`
+	Tree  *treeNode                  `json:"tree"`
+}
+
+// GetModule returns the live claim identified by the `group`, `version`,
+// `kind` and `name` path params (and `namespace` query param) along with
+// the tree of the composite and the resources it composes.
+func GetModule(opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		params := mux.Vars(r)
+
+		gvk := schema.GroupVersionKind{Group: params["group"], Version: params["version"], Kind: params["kind"]}
+		err := opts.Policy.Rules().AllowClaim(gvk.GroupKind())
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		ref := &unstructured.Unstructured{}
+		ref.SetGroupVersionKind(gvk)
+		ref.SetName(params["name"])
+		ref.SetNamespace(r.URL.Query().Get("namespace"))
+
+		clm, err := getResource(r.Context(), opts.Clients, ref)
+		if err != nil {
+			log.Warn().Msg(err.Error())
+			http.Error(w, err.Error(), lookupErrorStatus(err))
+			return
+		}
+
+		clm.SetManagedFields(nil)
+
+		res := &moduleStatus{
+			Claim: clm,
+			Tree:  newTreeNode(clm),
+		}
+		if xr := resourceRef(clm); xr != nil {
+			res.Tree.Children = []*treeNode{
+				composedTree(r.Context(), opts.Clients, xr, 1, map[string]bool{}),
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(res)
+		if err != nil {
+			log.Error().Msg(err.Error())
+		}
+	})
+}
+
+// lookupErrorStatus maps the error fetching an object to the reply status.
+func lookupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNamespaceRequired):
+		return http.StatusBadRequest
+	case apierrors.IsNotFound(err), meta.IsNoMatchError(err):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// composedTree fetches the referenced object and follows its
+// `spec.resourceRefs` recursively, up to maxTreeDepth levels.
+func composedTree(ctx context.Context, kf kubernetes.Factory, ref *unstructured.Unstructured, depth int, seen map[string]bool) *treeNode {
+	key := fmt.Sprintf("%s/%s/%s/%s", ref.GetAPIVersion(), ref.GetKind(), ref.GetNamespace(), ref.GetName())
+
+	obj, err := getResource(ctx, kf, ref)
+	if err != nil {
+		res := newTreeNode(ref)
+		res.Error = err.Error()
+		return res
+	}
+
+	res := newTreeNode(obj)
+	if seen[key] || depth >= maxTreeDepth {
+		return res
+	}
+	seen[key] = true
+
+	for _, el := range resourceRefs(obj) {
+		res.Children = append(res.Children, composedTree(ctx, kf, el, depth+1, seen))
+	}
+
+	return res
+}
+
+func newTreeNode(obj *unstructured.Unstructured) *treeNode {
+	conds := kubernetes.Conditions(obj)
+
+	res := &treeNode{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Name:       obj.GetName(),
+		Namespace:  obj.GetNamespace(),
+		Ready:      kubernetes.FindCondition(conds, conditionReady),
+		Synced:     kubernetes.FindCondition(conds, conditionSynced),
+	}
+
+	for _, c := range []*kubernetes.Condition{res.Synced, res.Ready} {
+		if c != nil && c.Status != string(metav1.ConditionTrue) && len(c.Message) > 0 {
+			res.Error = c.Message
+			break
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,68 @@
+package modules
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestComposedTree(t *testing.T) {
+	xr := withConditions(newObject(compositeGVK, "core-x5z7q", ""),
+		map[string]string{conditionReady: "False", conditionSynced: "True"})
+	unstructured.SetNestedSlice(xr.Object, []interface{}{
+		map[string]interface{}{"apiVersion": compositeGVK.GroupVersion().String(), "kind": compositeGVK.Kind, "name": "core-x5z7q-db"},
+		map[string]interface{}{"apiVersion": "s3.aws.crossplane.io/v1beta1", "kind": "Bucket", "name": "core-x5z7q-bucket"},
+	}, "spec", "resourceRefs")
+
+	nested := newObject(compositeGVK, "core-x5z7q-db", "")
+	// a cycle is not followed twice
+	unstructured.SetNestedSlice(nested.Object, []interface{}{
+		map[string]interface{}{"apiVersion": compositeGVK.GroupVersion().String(), "kind": compositeGVK.Kind, "name": "core-x5z7q"},
+	}, "spec", "resourceRefs")
+
+	kf := newFakeFactory(xr, nested)
+
+	res := composedTree(context.Background(), kf, newObject(compositeGVK, "core-x5z7q", ""), 1, map[string]bool{})
+	assert.Equal(t, "core-x5z7q", res.Name)
+	assert.Equal(t, "False", res.Ready.Status)
+	assert.Equal(t, "True", res.Synced.Status)
+	if !assert.Equal(t, 2, len(res.Children)) {
+		return
+	}
+
+	db := res.Children[0]
+	assert.Equal(t, "core-x5z7q-db", db.Name)
+	assert.Empty(t, db.Error)
+	if assert.Equal(t, 1, len(db.Children)) {
+		assert.Empty(t, db.Children[0].Children)
+	}
+
+	bucket := res.Children[1]
+	assert.Equal(t, "Bucket", bucket.Kind)
+	assert.NotEmpty(t, bucket.Error)
+}
+
+func TestComposedObjects(t *testing.T) {
+	xr := newObject(compositeGVK, "core-x5z7q", "")
+	unstructured.SetNestedSlice(xr.Object, []interface{}{
+		map[string]interface{}{"apiVersion": compositeGVK.GroupVersion().String(), "kind": compositeGVK.Kind, "name": "core-x5z7q-db"},
+	}, "spec", "resourceRefs")
+
+	nested := newObject(compositeGVK, "core-x5z7q-db", "")
+	// a cycle is not followed twice
+	unstructured.SetNestedSlice(nested.Object, []interface{}{
+		map[string]interface{}{"apiVersion": compositeGVK.GroupVersion().String(), "kind": compositeGVK.Kind, "name": "core-x5z7q"},
+		map[string]interface{}{"apiVersion": "s3.aws.crossplane.io/v1beta1", "kind": "Bucket", "name": "core-x5z7q-bucket"},
+	}, "spec", "resourceRefs")
+
+	kf := newFakeFactory(xr, nested)
+
+	res := composedObjects(context.Background(), kf, newObject(compositeGVK, "core-x5z7q", ""), 1, map[string]bool{})
+	names := make([]string, 0, len(res))
+	for _, el := range res {
+		names = append(names, el.GetName())
+	}
+	assert.Equal(t, []string{"core-x5z7q", "core-x5z7q-db", "core-x5z7q-bucket"}, names)
+}
@@ -1,14 +1,17 @@
 package modules
 
 import (
+	"context"
 	"time"
 
+	"github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+	"github.com/rs/zerolog"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
-	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/rest"
 )
 
 const (
@@ -16,76 +19,74 @@ const (
 	defaultMaxWait      = 5 * time.Minute
 )
 
-// waitForCRDs waits for the CRDs to appear in discovery.
-func waitForCRDs(config *rest.Config, crds []*apiextensionsv1.CustomResourceDefinition) error {
-	// Add each CRD to a map of GroupVersion to Resource
-	waitingFor := map[schema.GroupVersion]*sets.String{}
-	for _, crd := range crds {
-		gvs := []schema.GroupVersion{}
-		for _, version := range crd.Spec.Versions {
-			//if version.Served {
-			gvs = append(gvs, schema.GroupVersion{Group: crd.Spec.Group, Version: version.Name})
-			//}
-		}
-
-		for _, gv := range gvs {
-			//log.V(1).Info("adding API in waitlist", "GV", gv)
-			if _, found := waitingFor[gv]; !found {
-				// Initialize the set
-				waitingFor[gv] = &sets.String{}
-			}
-			// Add the Resource
-			waitingFor[gv].Insert(crd.Spec.Names.Plural)
-			//fmt.Printf(" gv: %+v\n", gv)
-		}
-	}
+// waitForKind waits for the CRD defining the specified kind to be
+// Established and NamesAccepted.
+//
+// The CRD is matched on `spec.group` and `spec.names.kind`, so the
+// wait relies on the real resource names (i.e. plural) of the kind.
+func waitForKind(ctx context.Context, crds kubernetes.CrdsWatcher, gvk *schema.GroupVersionKind) (*apiextensionsv1.CustomResourceDefinition, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultMaxWait)
+	defer cancel()
 
-	// Poll until all resources are found in discovery
-	p := &poller{config: config, waitingFor: waitingFor}
-	return wait.PollImmediate(defaultPollInterval, defaultMaxWait, p.poll)
+	return crds.WaitFor(ctx, *gvk)
 }
 
-// poller checks if all the resources have been found in discovery, and returns false if not.
-type poller struct {
-	// config is used to get discovery
-	config *rest.Config
+// waitForDeletion waits until all the specified objects are gone,
+// that is when their finalizers have been cleared. The objects the
+// bridge is not allowed to get (i.e. composed resources of groups
+// not granted by its RBAC) cannot be verified and are skipped.
+func waitForDeletion(ctx context.Context, kf kubernetes.Factory, objs []*unstructured.Unstructured) error {
+	log := zerolog.Ctx(ctx)
 
-	// waitingFor is the map of resources keyed by group version that have not yet been found in discovery
-	waitingFor map[schema.GroupVersion]*sets.String
-}
+	skipped := map[*unstructured.Unstructured]bool{}
+	err := wait.PollImmediateWithContext(ctx, defaultPollInterval, defaultMaxWait, func(ctx context.Context) (bool, error) {
+		for _, obj := range objs {
+			if skipped[obj] {
+				continue
+			}
 
-// poll checks if all the resources have been found in discovery, and returns false if not.
-func (p *poller) poll() (done bool, err error) {
-	// Create a new clientset to avoid any client caching of discovery
-	cs, err := clientset.NewForConfig(p.config)
-	if err != nil {
-		return false, err
-	}
+			_, err := getResource(ctx, kf, obj)
+			if err == nil {
+				return false, nil
+			}
 
-	allFound := true
-	for gv, resources := range p.waitingFor {
-		// All resources found, do nothing
-		if resources.Len() == 0 {
-			delete(p.waitingFor, gv)
-			continue
-		}
+			if errors.IsForbidden(err) {
+				log.Warn().
+					Str("apiVersion", obj.GetAPIVersion()).
+					Str("kind", obj.GetKind()).
+					Str("name", obj.GetName()).
+					Msg("cannot verify the resource deletion, skipping it")
+				skipped[obj] = true
+				continue
+			}
 
-		// Get the Resources for this GroupVersion
-		// TODO: Maybe the controller-runtime client should be able to do this...
-		resourceList, err := cs.Discovery().ServerResourcesForGroupVersion(gv.Group + "/" + gv.Version)
-		if err != nil {
-			return false, nil //nolint:nilerr
+			if !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+				return false, err
+			}
 		}
+		return true, nil
+	})
+	return cancelledErr(ctx, err)
+}
 
-		// Remove each found resource from the resources set that we are waiting for
-		for _, resource := range resourceList.APIResources {
-			resources.Delete(resource.Name)
-		}
+// waitForCRDsRemoval waits for the CRDs defining the specified kinds to be deleted.
+func waitForCRDsRemoval(ctx context.Context, crds kubernetes.CrdsWatcher, kinds []schema.GroupVersionKind) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultMaxWait)
+	defer cancel()
 
-		// Still waiting on some resources in this group version
-		if resources.Len() != 0 {
-			allFound = false
+	for _, el := range kinds {
+		if err := crds.WaitForRemoval(ctx, el); err != nil {
+			return err
 		}
 	}
-	return allFound, nil
+	return nil
+}
+
+// cancelledErr returns the context error in place of the timeout
+// reported by the wait helpers when the context has been cancelled.
+func cancelledErr(ctx context.Context, err error) error {
+	if err == wait.ErrWaitTimeout && ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	return err
 }
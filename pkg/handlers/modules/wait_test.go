@@ -0,0 +1,39 @@
+package modules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestWaitForDeletionForbidden(t *testing.T) {
+	releaseGVK := schema.GroupVersionKind{Group: "helm.crossplane.io", Version: "v1beta1", Kind: "Release"}
+
+	kf := newFakeFactory()
+	kf.mapper.(*meta.DefaultRESTMapper).Add(releaseGVK, meta.RESTScopeRoot)
+	kf.dynamic.(*fake.FakeDynamicClient).PrependReactor("get", "releases",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: releaseGVK.Group, Resource: "releases"}, "core-x5z7q-chart", nil)
+		})
+
+	objs := []*unstructured.Unstructured{
+		newObject(claimGVK, "core", "demo"),
+		newObject(compositeGVK, "core-x5z7q", ""),
+		newObject(releaseGVK, "core-x5z7q-chart", ""),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := waitForDeletion(ctx, kf, objs)
+	assert.Nil(t, err)
+}
@@ -14,15 +14,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation"
-	"k8s.io/client-go/rest"
 )
 
-func Create(cfg *rest.Config) http.Handler {
+// Create stores the secret of the payload, up to maxBodySize bytes.
+func Create(kf kubernetes.Factory, maxBodySize int64) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := zerolog.Ctx(r.Context())
 
 		var sd secretData
-		err := utils.DecodeJSONBody(w, r, &sd)
+		err := utils.DecodeJSONBody(w, r, &sd, maxBodySize)
 		if err != nil {
 			log.Warn().Msg(err.Error())
 
@@ -45,12 +45,7 @@ func Create(cfg *rest.Config) http.Handler {
 			return
 		}
 
-		kc, err := kubernetes.Secrets(cfg)
-		if err != nil {
-			log.Error().Msg(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		kc := kf.Secrets()
 
 		_, err = kc.Create(s.Namespace, s, metav1.CreateOptions{})
 		if err != nil {
@@ -11,19 +11,13 @@ import (
 	"github.com/rs/zerolog"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/rest"
 )
 
-func GetOne(cfg *rest.Config) http.Handler {
+func GetOne(kf kubernetes.Factory) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := zerolog.Ctx(r.Context())
 
-		kc, err := kubernetes.Secrets(cfg)
-		if err != nil {
-			log.Error().Msg(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		kc := kf.Secrets()
 
 		params := mux.Vars(r)
 
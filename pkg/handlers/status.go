@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
+)
+
+// StatusHandler reports the load of the workers pools, by name.
+func StatusHandler(pools map[string]workers.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := make(map[string]workers.Stats, len(pools))
+		for name, p := range pools {
+			data[name] = p.Stats()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(data)
+	})
+}
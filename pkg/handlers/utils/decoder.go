@@ -11,6 +11,10 @@ import (
 	"github.com/golang/gddo/httputil/header"
 )
 
+// DefaultMaxBodySize is the max size in bytes of the request
+// bodies, used when no (or a non positive) limit is specified.
+const DefaultMaxBodySize int64 = 1048576
+
 type MalformedRequest struct {
 	Status int
 	Msg    string
@@ -20,7 +24,9 @@ func (mr *MalformedRequest) Error() string {
 	return mr.Msg
 }
 
-func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+// DecodeJSONBody decodes the single JSON object of the request
+// body, up to maxBodySize bytes, into dst.
+func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}, maxBodySize int64) error {
 	if r.Header.Get("Content-Type") != "" {
 		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
 		if value != "application/json" {
@@ -29,7 +35,8 @@ func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 		}
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	maxBodySize = BodyLimit(maxBodySize)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
@@ -61,9 +68,8 @@ func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 			msg := "Request body must not be empty"
 			return &MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
 
-		case err.Error() == "http: request body too large":
-			msg := "Request body must not be larger than 1MB"
-			return &MalformedRequest{Status: http.StatusRequestEntityTooLarge, Msg: msg}
+		case IsBodyTooLarge(err):
+			return BodyTooLarge(maxBodySize)
 
 		default:
 			return err
@@ -78,3 +84,23 @@ func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 
 	return nil
 }
+
+// IsBodyTooLarge tells if the error is due to a body
+// exceeding the limit set by http.MaxBytesReader.
+func IsBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// BodyLimit returns the limit, or DefaultMaxBodySize when not positive.
+func BodyLimit(maxBodySize int64) int64 {
+	if maxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return maxBodySize
+}
+
+// BodyTooLarge returns the error for a body larger than maxBodySize.
+func BodyTooLarge(maxBodySize int64) *MalformedRequest {
+	msg := fmt.Sprintf("Request body must not be larger than %d bytes", maxBodySize)
+	return &MalformedRequest{Status: http.StatusRequestEntityTooLarge, Msg: msg}
+}
@@ -0,0 +1,235 @@
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+
+	kbkubernetes "github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+)
+
+const (
+	// LabelHistory marks the Secrets holding the module revisions.
+	LabelHistory = "kube-bridge.krateo.io/history"
+	// AnnotationModule holds the identity of the module of the history.
+	AnnotationModule = "kube-bridge.krateo.io/module"
+	// KeyRevisions is the Secret key holding the JSON revisions.
+	KeyRevisions = "revisions.json"
+	// DefaultLimit is the number of revisions kept per module.
+	DefaultLimit = 10
+
+	secretPrefix = "kube-bridge-history-"
+	storeTimeout = 10 * time.Second
+)
+
+// ErrNotFound is returned when the module has no such revision.
+var ErrNotFound = errors.New("revision not found")
+
+// Module identifies a module by its claim.
+type Module struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// GroupKind returns the GroupKind of the module claim.
+func (m Module) GroupKind() schema.GroupKind {
+	return schema.GroupKind{Group: m.Group, Kind: m.Kind}
+}
+
+func (m Module) String() string {
+	return fmt.Sprintf("%s.%s/%s/%s", m.Kind, m.Group, m.Namespace, m.Name)
+}
+
+// Revision is a module spec applied by an install.
+type Revision struct {
+	Revision     int       `json:"revision"`
+	DeploymentID string    `json:"deploymentId"`
+	Time         time.Time `json:"time"`
+	// Caller is who sent the request that applied the revision.
+	Caller   string                       `json:"caller,omitempty"`
+	Packages []*unstructured.Unstructured `json:"packages"`
+	Claim    *unstructured.Unstructured   `json:"claim"`
+}
+
+// Store keeps a bounded history of the revisions of each module.
+type Store interface {
+	// Record appends the revision to the module history,
+	// dropping the oldest ones beyond the limit, and
+	// returns the number assigned to the revision.
+	Record(m Module, rev *Revision) (int, error)
+	// List returns the revisions of the module, the newest first.
+	List(m Module) ([]*Revision, error)
+	// Get returns the revision of the module with the specified number.
+	Get(m Module, revision int) (*Revision, error)
+}
+
+// NewSecretStore returns a store keeping the history of each module in
+// a Secret of the specified namespace, up to limit revisions. Secrets,
+// rather than ConfigMaps, since the claim specs can carry credentials.
+func NewSecretStore(c *rest.Config, namespace string, limit int) (Store, error) {
+	cs, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecretStore(cs, namespace, limit), nil
+}
+
+func newSecretStore(cs kubernetes.Interface, namespace string, limit int) *secretStore {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	return &secretStore{
+		client: cs.CoreV1().Secrets(namespace),
+		limit:  limit,
+	}
+}
+
+type secretStore struct {
+	client corev1client.SecretInterface
+	limit  int
+}
+
+func (s *secretStore) Record(m Module, rev *Revision) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	var res int
+	err := retry.OnError(retry.DefaultRetry, isRetriable, func() error {
+		sec, err := s.client.Get(ctx, secretName(m), metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		exists := err == nil
+
+		var all []*Revision
+		if exists {
+			if all, err = decodeRevisions(sec); err != nil {
+				return err
+			}
+		}
+
+		res = 1
+		if len(all) > 0 {
+			res = all[len(all)-1].Revision + 1
+		}
+
+		el := *rev
+		el.Revision = res
+		all = append(all, &el)
+		if len(all) > s.limit {
+			all = all[len(all)-s.limit:]
+		}
+
+		dat, err := json.Marshal(all)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			sec = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: secretName(m),
+					Labels: map[string]string{
+						kbkubernetes.LabelManagedBy: kbkubernetes.DefaultFieldManager,
+						LabelHistory:                "true",
+					},
+					Annotations: map[string]string{
+						AnnotationModule: m.String(),
+					},
+				},
+				Type: corev1.SecretTypeOpaque,
+				Data: map[string][]byte{KeyRevisions: dat},
+			}
+
+			_, err = s.client.Create(ctx, sec, metav1.CreateOptions{})
+			return err
+		}
+
+		sec.Data = map[string][]byte{KeyRevisions: dat}
+		_, err = s.client.Update(ctx, sec, metav1.UpdateOptions{})
+		return err
+	})
+
+	return res, err
+}
+
+func (s *secretStore) List(m Module) ([]*Revision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	sec, err := s.client.Get(ctx, secretName(m), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return []*Revision{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := decodeRevisions(sec)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Revision, 0, len(all))
+	for i := len(all) - 1; i >= 0; i-- {
+		res = append(res, all[i])
+	}
+	return res, nil
+}
+
+func (s *secretStore) Get(m Module, revision int) (*Revision, error) {
+	all, err := s.List(m)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, el := range all {
+		if el.Revision == revision {
+			return el, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w (module: %s, revision: %d)", ErrNotFound, m.String(), revision)
+}
+
+// secretName derives a valid name from the module identity.
+func secretName(m Module) string {
+	sum := sha256.Sum256([]byte(m.String()))
+	return secretPrefix + hex.EncodeToString(sum[:])[:32]
+}
+
+func decodeRevisions(sec *corev1.Secret) ([]*Revision, error) {
+	dat, ok := sec.Data[KeyRevisions]
+	if !ok {
+		return nil, nil
+	}
+
+	var res []*Revision
+	if err := json.Unmarshal(dat, &res); err != nil {
+		return nil, fmt.Errorf("decoding secret: %s: %w", sec.Name, err)
+	}
+	return res, nil
+}
+
+// isRetriable tells if the write lost a race with another one.
+func isRetriable(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+}
@@ -0,0 +1,57 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretStore(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	s := newSecretStore(cs, "krateo-system", 2)
+
+	m := Module{Group: "modules.krateo.io", Kind: "Core", Namespace: "demo", Name: "core"}
+
+	for i, id := range []string{"abc", "def", "ghi"} {
+		clm := &unstructured.Unstructured{}
+		clm.SetAPIVersion("modules.krateo.io/v1alpha1")
+		clm.SetKind("Core")
+		clm.SetName("core")
+		unstructured.SetNestedField(clm.Object, id, "spec", "release")
+
+		n, err := s.Record(m, &Revision{DeploymentID: id, Time: time.Now(), Claim: clm})
+		assert.Nil(t, err)
+		assert.Equal(t, i+1, n)
+	}
+
+	// the claims can carry credentials
+	secs, err := cs.CoreV1().Secrets("krateo-system").List(context.Background(), metav1.ListOptions{LabelSelector: LabelHistory})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(secs.Items))
+
+	all, err := s.List(m)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(all)) {
+		assert.Equal(t, 3, all[0].Revision)
+		assert.Equal(t, 2, all[1].Revision)
+	}
+
+	rev, err := s.Get(m, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "def", rev.DeploymentID)
+	release, _, _ := unstructured.NestedString(rev.Claim.Object, "spec", "release")
+	assert.Equal(t, "def", release)
+
+	_, err = s.Get(m, 1)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	all, err = s.List(Module{Group: "modules.krateo.io", Kind: "Core", Namespace: "demo", Name: "other"})
+	assert.Nil(t, err)
+	assert.Empty(t, all)
+}
@@ -0,0 +1,56 @@
+package kubernetes
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Condition is the status condition shape shared by
+// Crossplane packages, composite resources and claims.
+type Condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// Conditions returns the `status.conditions` of the specified object.
+func Conditions(obj *unstructured.Unstructured) []Condition {
+	items, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil || !found {
+		return []Condition{}
+	}
+
+	res := make([]Condition, 0, len(items))
+	for _, el := range items {
+		m, ok := el.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		res = append(res, Condition{
+			Type:               stringValue(m, "type"),
+			Status:             stringValue(m, "status"),
+			Reason:             stringValue(m, "reason"),
+			Message:            stringValue(m, "message"),
+			LastTransitionTime: stringValue(m, "lastTransitionTime"),
+		})
+	}
+
+	return res
+}
+
+// FindCondition returns the condition with the specified type or nil.
+func FindCondition(conds []Condition, typ string) *Condition {
+	for i := range conds {
+		if conds[i].Type == typ {
+			return &conds[i]
+		}
+	}
+	return nil
+}
+
+func stringValue(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
@@ -0,0 +1,188 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// CrdsWatcher shares a single watch on CustomResourceDefinitions
+// among all the callers waiting for a kind to be (un)defined.
+type CrdsWatcher interface {
+	// Start runs the underlying informer until stopCh is closed.
+	Start(stopCh <-chan struct{})
+	// WaitFor blocks until the CRD defining the kind is
+	// Established and NamesAccepted, or the context is done.
+	WaitFor(ctx context.Context, gvk schema.GroupVersionKind) (*apiextensionsv1.CustomResourceDefinition, error)
+	// WaitForRemoval blocks until no CRD defines the kind, or the context is done.
+	WaitForRemoval(ctx context.Context, gvk schema.GroupVersionKind) error
+	// List returns the CRDs known to the informer.
+	List() []*apiextensionsv1.CustomResourceDefinition
+	// Generation returns a counter increased on every CRD event.
+	Generation() uint64
+}
+
+func NewCrdsWatcher(c *rest.Config) (CrdsWatcher, error) {
+	cs, err := clientset.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCrdsWatcher(cs), nil
+}
+
+func newCrdsWatcher(cs clientset.Interface) *crdsWatcherImpl {
+	factory := externalversions.NewSharedInformerFactory(cs, 0)
+
+	res := &crdsWatcherImpl{
+		informer: factory.Apiextensions().V1().CustomResourceDefinitions().Informer(),
+		changed:  make(chan struct{}),
+	}
+
+	res.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(_ interface{}) { res.broadcast() },
+		UpdateFunc: func(_, _ interface{}) { res.broadcast() },
+		DeleteFunc: func(_ interface{}) { res.broadcast() },
+	})
+
+	return res
+}
+
+type crdsWatcherImpl struct {
+	informer cache.SharedIndexInformer
+
+	lock sync.Mutex
+	// changed is closed, and replaced, on every CRD event
+	// in order to wake up all the waiters at once.
+	changed chan struct{}
+	// generation counts the CRD events
+	generation uint64
+}
+
+func (impl *crdsWatcherImpl) Start(stopCh <-chan struct{}) {
+	go impl.informer.Run(stopCh)
+}
+
+func (impl *crdsWatcherImpl) WaitFor(ctx context.Context, gvk schema.GroupVersionKind) (*apiextensionsv1.CustomResourceDefinition, error) {
+	var res *apiextensionsv1.CustomResourceDefinition
+	err := impl.wait(ctx, func() bool {
+		res = impl.find(gvk)
+		return res != nil && gvk.Version != "" && isEstablished(res, gvk.Version)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for CRD (apiVersion: %s, kind: %s): %w", gvk.GroupVersion().String(), gvk.Kind, err)
+	}
+
+	return res, nil
+}
+
+func (impl *crdsWatcherImpl) WaitForRemoval(ctx context.Context, gvk schema.GroupVersionKind) error {
+	err := impl.wait(ctx, func() bool {
+		return impl.find(gvk) == nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for CRD (apiVersion: %s, kind: %s) removal: %w", gvk.GroupVersion().String(), gvk.Kind, err)
+	}
+
+	return nil
+}
+
+// wait blocks until done is satisfied, checking it again after every CRD event.
+func (impl *crdsWatcherImpl) wait(ctx context.Context, done func() bool) error {
+	if !cache.WaitForCacheSync(ctx.Done(), impl.informer.HasSynced) {
+		return ctx.Err()
+	}
+
+	for {
+		impl.lock.Lock()
+		ch := impl.changed
+		impl.lock.Unlock()
+
+		if done() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+func (impl *crdsWatcherImpl) broadcast() {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	close(impl.changed)
+	impl.changed = make(chan struct{})
+	impl.generation++
+}
+
+func (impl *crdsWatcherImpl) Generation() uint64 {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	return impl.generation
+}
+
+func (impl *crdsWatcherImpl) List() []*apiextensionsv1.CustomResourceDefinition {
+	all := impl.informer.GetStore().List()
+
+	res := make([]*apiextensionsv1.CustomResourceDefinition, 0, len(all))
+	for _, el := range all {
+		if crd, ok := el.(*apiextensionsv1.CustomResourceDefinition); ok {
+			res = append(res, crd)
+		}
+	}
+	return res
+}
+
+// find returns the CRD defining the kind in the group, if any.
+func (impl *crdsWatcherImpl) find(gvk schema.GroupVersionKind) *apiextensionsv1.CustomResourceDefinition {
+	for _, el := range impl.informer.GetStore().List() {
+		crd, ok := el.(*apiextensionsv1.CustomResourceDefinition)
+		if !ok {
+			continue
+		}
+
+		if crd.Spec.Group == gvk.Group && crd.Spec.Names.Kind == gvk.Kind {
+			return crd
+		}
+	}
+	return nil
+}
+
+// isEstablished tells if the CRD serves the version
+// and has been accepted and established by the apiserver.
+func isEstablished(crd *apiextensionsv1.CustomResourceDefinition, version string) bool {
+	served := false
+	for _, el := range crd.Spec.Versions {
+		if el.Name == version && el.Served {
+			served = true
+			break
+		}
+	}
+	if !served {
+		return false
+	}
+
+	established, namesAccepted := false, false
+	for _, el := range crd.Status.Conditions {
+		switch el.Type {
+		case apiextensionsv1.Established:
+			established = el.Status == apiextensionsv1.ConditionTrue
+		case apiextensionsv1.NamesAccepted:
+			namesAccepted = el.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+
+	return established && namesAccepted
+}
@@ -0,0 +1,90 @@
+package kubernetes
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCrdsWatcher_SharedWaiters(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	w := newCrdsWatcher(cs)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	w.Start(stopCh)
+
+	gvk := schema.GroupVersionKind{Group: "modules.krateo.io", Version: "v1alpha1", Kind: "Policy"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			crd, err := w.WaitFor(ctx, gvk)
+			if err == nil && crd.Spec.Names.Plural != "policies" {
+				t.Errorf("unexpected plural: %s", crd.Spec.Names.Plural)
+			}
+			errs <- err
+		}()
+	}
+
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "policies.modules.krateo.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: gvk.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: gvk.Kind, Plural: "policies"},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: gvk.Version, Served: true, Storage: true},
+			},
+		},
+	}
+	crd, err := cs.ApiextensionsV1().CustomResourceDefinitions().Create(ctx, crd, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	// not yet established
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, len(errs))
+
+	crd.Status.Conditions = []apiextensionsv1.CustomResourceDefinitionCondition{
+		{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+		{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+	}
+	_, err = cs.ApiextensionsV1().CustomResourceDefinitions().UpdateStatus(ctx, crd, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	err = cs.ApiextensionsV1().CustomResourceDefinitions().Delete(ctx, crd.Name, metav1.DeleteOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, w.WaitForRemoval(ctx, gvk))
+}
+
+func TestCrdsWatcher_WaitForTimeout(t *testing.T) {
+	w := newCrdsWatcher(fake.NewSimpleClientset())
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	w.Start(stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err := w.WaitFor(ctx, schema.GroupVersionKind{Group: "modules.krateo.io", Version: "v1", Kind: "Core"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,116 @@
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	memory "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// Factory provides the clients shared by the whole process.
+type Factory interface {
+	// RESTConfig returns the configuration used to build the clients.
+	RESTConfig() *rest.Config
+	// Dynamic returns the shared dynamic client.
+	Dynamic() dynamic.Interface
+	// Secrets returns the shared secrets client.
+	Secrets() SecretsClient
+	// RESTMapping finds the resource for the specified kind using a cached
+	// discovery. On a miss (i.e. the CRD has just been created) the cache is
+	// invalidated and the lookup is retried once, but only if the CRDs
+	// changed since the last invalidation or resetInterval has elapsed.
+	RESTMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error)
+}
+
+// resetInterval bounds how often a miss invalidates the discovery
+// cache when the CRDs watcher reports no change.
+const resetInterval = time.Minute
+
+// NewFactory builds the shared clients; crds, if not nil,
+// tells when the cached discovery is worth invalidating.
+func NewFactory(c *rest.Config, crds CrdsWatcher) (Factory, error) {
+	dc, err := dynamic.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := Secrets(c)
+	if err != nil {
+		return nil, err
+	}
+
+	disco, err := discovery.NewDiscoveryClientForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &factoryImpl{
+		config:  c,
+		dynamic: dc,
+		secrets: sc,
+		mapper:  restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disco)),
+		crds:    crds,
+	}, nil
+}
+
+type factoryImpl struct {
+	config  *rest.Config
+	dynamic dynamic.Interface
+	secrets SecretsClient
+	mapper  *restmapper.DeferredDiscoveryRESTMapper
+	crds    CrdsWatcher
+
+	lock     sync.Mutex
+	resetGen uint64
+	resetAt  time.Time
+}
+
+func (impl *factoryImpl) RESTConfig() *rest.Config {
+	return impl.config
+}
+
+func (impl *factoryImpl) Dynamic() dynamic.Interface {
+	return impl.dynamic
+}
+
+func (impl *factoryImpl) Secrets() SecretsClient {
+	return impl.secrets
+}
+
+func (impl *factoryImpl) RESTMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	res, err := impl.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err == nil || !meta.IsNoMatchError(err) {
+		return res, err
+	}
+
+	if !impl.shouldReset() {
+		return res, err
+	}
+	impl.mapper.Reset()
+
+	return impl.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+// shouldReset tells whether a miss can invalidate the discovery cache,
+// i.e. a CRD changed or resetInterval elapsed since the last invalidation.
+func (impl *factoryImpl) shouldReset() bool {
+	var gen uint64
+	if impl.crds != nil {
+		gen = impl.crds.Generation()
+	}
+
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	if gen == impl.resetGen && time.Since(impl.resetAt) < resetInterval {
+		return false
+	}
+	impl.resetGen, impl.resetAt = gen, time.Now()
+	return true
+}
@@ -0,0 +1,57 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	memory "k8s.io/client-go/discovery/cached"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/restmapper"
+	kubetesting "k8s.io/client-go/testing"
+)
+
+type fakeGeneration struct {
+	CrdsWatcher
+	gen uint64
+}
+
+func (f *fakeGeneration) Generation() uint64 {
+	return f.gen
+}
+
+func TestFactoryRESTMappingResets(t *testing.T) {
+	disco := &fakediscovery.FakeDiscovery{Fake: &kubetesting.Fake{
+		Resources: []*metav1.APIResourceList{{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{{Name: "pods", Kind: "Pod", Namespaced: true}},
+		}},
+	}}
+	crds := &fakeGeneration{}
+
+	kf := &factoryImpl{
+		mapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disco)),
+		crds:   crds,
+	}
+
+	gvk := schema.GroupVersionKind{Group: "modules.krateo.io", Version: "v1alpha1", Kind: "Core"}
+
+	_, err := kf.RESTMapping(gvk)
+	assert.True(t, meta.IsNoMatchError(err))
+	calls := len(disco.Actions())
+
+	// no CRD change: the misses keep the cached discovery
+	for i := 0; i < 3; i++ {
+		_, err = kf.RESTMapping(gvk)
+		assert.True(t, meta.IsNoMatchError(err))
+	}
+	assert.Equal(t, calls, len(disco.Actions()))
+
+	// a CRD change lets the next miss invalidate it
+	crds.gen++
+	_, err = kf.RESTMapping(gvk)
+	assert.True(t, meta.IsNoMatchError(err))
+	assert.Greater(t, len(disco.Actions()), calls)
+}
@@ -7,4 +7,10 @@ const (
 
 	LabelManagedBy      = "app.kubernetes.io/managed-by"
 	DefaultFieldManager = "krateoplatformops"
+
+	// LabelOwned marks the objects applied by the bridge.
+	LabelOwned = "kube-bridge.krateo.io/owned"
+	// AnnotationDeploymentID holds the `X-Deployment-Id`
+	// of the last operation that applied the object.
+	AnnotationDeploymentID = "kube-bridge.krateo.io/deployment-id"
 )
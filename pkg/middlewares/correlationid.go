@@ -41,3 +41,14 @@ func CorrelationID(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// DeploymentID returns the correlation identifier
+// stored in the context by the CorrelationID middleware.
+func DeploymentID(ctx context.Context) string {
+	id, ok := ctx.Value(DeploymentIdKey).(string)
+	if ok {
+		return id
+	}
+
+	return ""
+}
@@ -0,0 +1,14 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timeout returns a middleware that bounds the time spent
+// serving a request, replying 503 when it is exceeded.
+func Timeout(d time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, http.StatusText(http.StatusServiceUnavailable))
+	}
+}
@@ -0,0 +1,111 @@
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	kbkubernetes "github.com/krateoplatformops/kube-bridge/pkg/kubernetes"
+)
+
+const (
+	// LabelOperation marks the ConfigMaps holding the operations.
+	LabelOperation = "kube-bridge.krateo.io/operation"
+	// KeyOperation is the ConfigMap key holding the JSON operation.
+	KeyOperation = "operation.json"
+
+	configMapPrefix = "kube-bridge-op-"
+	storeTimeout    = 10 * time.Second
+)
+
+// NewConfigMapStore returns a store keeping one ConfigMap
+// per operation in the specified namespace.
+//
+// The ConfigMap data is bounded to 1MiB, plenty
+// for the steps of a module operation.
+func NewConfigMapStore(cs kubernetes.Interface, namespace string) Store {
+	return &configMapStore{
+		client: cs.CoreV1().ConfigMaps(namespace),
+	}
+}
+
+type configMapStore struct {
+	client corev1client.ConfigMapInterface
+}
+
+func (s *configMapStore) Save(op *Operation) error {
+	dat, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	name := configMapPrefix + storeKey(op.ID)
+
+	cm, err := s.client.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					kbkubernetes.LabelManagedBy: kbkubernetes.DefaultFieldManager,
+					LabelOperation:              "true",
+				},
+			},
+			Data: map[string]string{KeyOperation: string(dat)},
+		}
+
+		_, err = s.client.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	cm.Data = map[string]string{KeyOperation: string(dat)}
+	_, err = s.client.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *configMapStore) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	err := s.client.Delete(ctx, configMapPrefix+storeKey(id), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *configMapStore) List() ([]*Operation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	all, err := s.client.List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true", LabelOperation),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Operation, 0, len(all.Items))
+	for _, cm := range all.Items {
+		op := &Operation{}
+		if err := json.Unmarshal([]byte(cm.Data[KeyOperation]), op); err != nil {
+			return nil, fmt.Errorf("decoding configmap: %s: %w", cm.Name, err)
+		}
+		res = append(res, op)
+	}
+
+	return res, nil
+}
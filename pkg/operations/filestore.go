@@ -0,0 +1,95 @@
+package operations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storeKey returns a name safe for files and objects
+// derived from the operation identifier.
+func storeKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// NewFileStore returns a store keeping one JSON file
+// per operation in the specified directory.
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &fileStore{dir: dir}, nil
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Save(op *Operation) error {
+	dat, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so that a crash never leaves a truncated file
+	tmp, err := os.CreateTemp(s.dir, ".op-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(dat); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(op.ID))
+}
+
+func (s *fileStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileStore) List() ([]*Operation, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Operation, 0, len(entries))
+	for _, el := range entries {
+		if el.IsDir() || strings.HasPrefix(el.Name(), ".") || filepath.Ext(el.Name()) != ".json" {
+			continue
+		}
+
+		dat, err := os.ReadFile(filepath.Join(s.dir, el.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		op := &Operation{}
+		if err := json.Unmarshal(dat, op); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", el.Name(), err)
+		}
+		res = append(res, op)
+	}
+
+	return res, nil
+}
+
+func (s *fileStore) path(id string) string {
+	return filepath.Join(s.dir, storeKey(id)+".json")
+}
@@ -0,0 +1,136 @@
+package operations
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Locks serializes the operations touching the same keys (i.e. the same
+// module claim or package). The keys are always acquired in sorted order,
+// so that operations sharing more than one key cannot deadlock.
+type Locks interface {
+	// TryLock acquires all the keys without waiting,
+	// ok is false if any of them is already held.
+	TryLock(keys []string) (unlock func(), ok bool)
+	// Lock acquires all the keys, waiting for the previous holders
+	// in arrival order, until the context is done.
+	Lock(ctx context.Context, keys []string) (unlock func(), err error)
+}
+
+// NewLocks returns a new in memory keyed lock.
+func NewLocks() Locks {
+	return &locks{
+		items: make(map[string]*keyLock),
+	}
+}
+
+type locks struct {
+	lock  sync.Mutex
+	items map[string]*keyLock
+}
+
+// keyLock is held by sending on ch, the blocked
+// senders are woken up in first-in first-out order.
+type keyLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+func (impl *locks) TryLock(keys []string) (func(), bool) {
+	keys = sortedKeys(keys)
+
+	held := make([]string, 0, len(keys))
+	for _, k := range keys {
+		kl := impl.ref(k)
+		select {
+		case kl.ch <- struct{}{}:
+			held = append(held, k)
+		default:
+			impl.unref(k)
+			impl.release(held)
+			return nil, false
+		}
+	}
+
+	return impl.unlocker(held), true
+}
+
+func (impl *locks) Lock(ctx context.Context, keys []string) (func(), error) {
+	keys = sortedKeys(keys)
+
+	held := make([]string, 0, len(keys))
+	for _, k := range keys {
+		kl := impl.ref(k)
+		select {
+		case kl.ch <- struct{}{}:
+			held = append(held, k)
+		case <-ctx.Done():
+			impl.unref(k)
+			impl.release(held)
+			return nil, ctx.Err()
+		}
+	}
+
+	return impl.unlocker(held), nil
+}
+
+func (impl *locks) unlocker(keys []string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { impl.release(keys) })
+	}
+}
+
+func (impl *locks) release(keys []string) {
+	for i := len(keys) - 1; i >= 0; i-- {
+		impl.lock.Lock()
+		kl := impl.items[keys[i]]
+		impl.lock.Unlock()
+
+		<-kl.ch
+		impl.unref(keys[i])
+	}
+}
+
+func (impl *locks) ref(key string) *keyLock {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	kl, ok := impl.items[key]
+	if !ok {
+		kl = &keyLock{ch: make(chan struct{}, 1)}
+		impl.items[key] = kl
+	}
+	kl.refs++
+	return kl
+}
+
+func (impl *locks) unref(key string) {
+	impl.lock.Lock()
+	defer impl.lock.Unlock()
+
+	kl, ok := impl.items[key]
+	if !ok {
+		return
+	}
+
+	kl.refs--
+	if kl.refs <= 0 {
+		delete(impl.items, key)
+	}
+}
+
+func sortedKeys(keys []string) []string {
+	set := make(map[string]struct{}, len(keys))
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if _, ok := set[k]; ok {
+			continue
+		}
+		set[k] = struct{}{}
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
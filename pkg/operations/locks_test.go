@@ -0,0 +1,73 @@
+package operations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocks_TryLock(t *testing.T) {
+	l := NewLocks()
+
+	unlock, ok := l.TryLock([]string{"claim:core", "package:core"})
+	assert.True(t, ok)
+
+	_, ok = l.TryLock([]string{"package:core", "package:runtime"})
+	assert.False(t, ok)
+
+	// the keys acquired before the failure have been released
+	unlockRuntime, ok := l.TryLock([]string{"package:runtime"})
+	assert.True(t, ok)
+	unlockRuntime()
+
+	unlock()
+	unlock()
+
+	unlock, ok = l.TryLock([]string{"package:core"})
+	assert.True(t, ok)
+	unlock()
+
+	assert.Equal(t, 0, len(l.(*locks).items))
+}
+
+func TestLocks_LockInOrder(t *testing.T) {
+	l := NewLocks()
+
+	unlock, err := l.Lock(context.Background(), []string{"claim:core"})
+	assert.Nil(t, err)
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			unlock, err := l.Lock(context.Background(), []string{"claim:core"})
+			if err == nil {
+				order <- i
+				unlock()
+			}
+		}(i)
+		// let the waiter block before the next one arrives
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	unlock()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, i, <-order)
+	}
+}
+
+func TestLocks_LockContextDone(t *testing.T) {
+	l := NewLocks()
+
+	unlock, ok := l.TryLock([]string{"claim:core"})
+	assert.True(t, ok)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := l.Lock(ctx, []string{"claim:core"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
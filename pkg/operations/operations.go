@@ -0,0 +1,101 @@
+package operations
+
+import (
+	"context"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/support"
+)
+
+// State is the lifecycle phase of an operation.
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+	// StateInterrupted marks the operations still pending
+	// or running when the service has been shut down.
+	StateInterrupted State = "interrupted"
+)
+
+// Finished reports whether the state is terminal.
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled || s == StateInterrupted
+}
+
+// Kind identifies what an operation does.
+type Kind string
+
+const (
+	KindInstall Kind = "install"
+	KindDelete  Kind = "delete"
+)
+
+// Operation tracks a background module install or delete
+// started by a `/template` request.
+type Operation struct {
+	ID        string                  `json:"deploymentId"`
+	Kind      Kind                    `json:"kind"`
+	Digest    string                  `json:"digest,omitempty"`
+	State     State                   `json:"state"`
+	Steps     []*support.Notification `json:"steps"`
+	Resources []Resource              `json:"resources,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+	// Conflict details the failure of a job
+	// whose error is a ConflictError.
+	Conflict   interface{} `json:"conflict,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+	// CancelledAt is set as soon as the cancellation is requested,
+	// the state turns to cancelled once the job has stopped.
+	CancelledAt *time.Time `json:"cancelledAt,omitempty"`
+	// Rollback tells whether the cancellation
+	// asked to undo the changes already made.
+	Rollback bool `json:"rollback,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// ConflictError is implemented by the job errors reporting the fields
+// owned by other managers: the conflict is kept on the failed operation,
+// so that it is available to the callers not waiting for the outcome.
+type ConflictError interface {
+	error
+	Conflict() interface{}
+}
+
+// Resource is an object touched by an operation
+// along with the last action applied to it.
+type Resource struct {
+	support.ResourceRef
+	Action string `json:"action"`
+}
+
+// Location returns the path of the operation resource.
+func Location(id string) string {
+	return "/operations/" + id
+}
+
+func (op *Operation) clone() *Operation {
+	res := *op
+	res.Steps = make([]*support.Notification, len(op.Steps))
+	copy(res.Steps, op.Steps)
+	res.Resources = make([]Resource, len(op.Resources))
+	copy(res.Resources, op.Resources)
+	return &res
+}
+
+func (op *Operation) addResource(ref support.ResourceRef, action string) {
+	for i := range op.Resources {
+		if op.Resources[i].ResourceRef == ref {
+			op.Resources[i].Action = action
+			return
+		}
+	}
+
+	op.Resources = append(op.Resources, Resource{ResourceRef: ref, Action: action})
+}
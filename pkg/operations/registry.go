@@ -0,0 +1,401 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
+	"github.com/krateoplatformops/kube-bridge/pkg/support"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultRetention = time.Hour
+	// coalesceDelay batches the changes (i.e. the steps
+	// of an operation) following each other into a single write.
+	coalesceDelay = 200 * time.Millisecond
+)
+
+var (
+	// ErrInProgress is returned when an operation of another
+	// kind with the same identifier is still pending or running.
+	ErrInProgress = errors.New("operation already in progress")
+	// ErrPayloadMismatch is returned when an operation with the same
+	// identifier has been started with a different payload.
+	ErrPayloadMismatch = errors.New("operation already started with a different payload")
+	// ErrNotFound is returned when no operation with
+	// the specified identifier is tracked.
+	ErrNotFound = errors.New("operation not found")
+	// ErrFinished is returned when cancelling an operation already finished.
+	ErrFinished = errors.New("operation already finished")
+)
+
+// Registry keeps track of the module operations keyed
+// by the `X-Deployment-Id` correlation identifier.
+type Registry interface {
+	// Begin registers a new pending operation, unless an operation of the same
+	// kind and payload digest is already tracked: in that case the existing
+	// operation is returned with existing set to true.
+	Begin(id string, kind Kind, digest string) (op *Operation, existing bool, err error)
+	// Discard removes a pending operation that will not be run.
+	Discard(id string)
+	// Run executes fn updating the state of the operation, the context
+	// passed to fn is cancelled when the operation is cancelled.
+	Run(ctx context.Context, id string, fn func(ctx context.Context) error) error
+	// Cancel cancels a pending or running operation, with rollback the job
+	// is asked to undo the changes already made before stopping.
+	Cancel(id string, rollback bool) (*Operation, error)
+	// Interrupt marks all the pending and running operations as interrupted,
+	// cancelling their context, and returns their identifiers.
+	Interrupt() []string
+	// Rollback reports whether the operation has been
+	// cancelled asking for the changes to be undone.
+	Rollback(id string) bool
+	// Get returns a snapshot of the operation with the specified id.
+	Get(id string) (*Operation, bool)
+	// Record appends the published notifications to the related operation steps.
+	Record(e eventbus.Event)
+	// Flush writes the pending changes to the store.
+	Flush()
+}
+
+// New returns a new in memory operation registry that keeps the finished
+// operations for the retention window (zero means the default, one hour).
+func New(retention time.Duration) Registry {
+	return newRegistry(retention, nil, zerolog.Nop())
+}
+
+// Open returns a new operation registry persisted to the store, loading the
+// operations saved by the previous process: those that were still pending
+// or running are marked as interrupted and their identifiers returned.
+//
+// An interrupted operation is resumed by sending again its `/template` request.
+func Open(store Store, retention time.Duration, log zerolog.Logger) (Registry, []string, error) {
+	all, err := store.List()
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading operations: %w", err)
+	}
+
+	reg := newRegistry(retention, store, log)
+
+	interrupted := []string{}
+	reg.lock.Lock()
+	for _, op := range all {
+		if !op.State.Finished() {
+			now := time.Now()
+			op.State = StateInterrupted
+			op.Error = "interrupted by service restart"
+			op.FinishedAt = &now
+			interrupted = append(interrupted, op.ID)
+			reg.touch(op.ID)
+		}
+		reg.items[op.ID] = op
+	}
+	reg.prune()
+	reg.lock.Unlock()
+
+	reg.Flush()
+	go reg.persist()
+
+	sort.Strings(interrupted)
+	return reg, interrupted, nil
+}
+
+func newRegistry(retention time.Duration, store Store, log zerolog.Logger) *registry {
+	if retention <= 0 {
+		retention = defaultRetention
+	}
+
+	return &registry{
+		items:     make(map[string]*Operation),
+		retention: retention,
+		store:     store,
+		dirty:     make(map[string]struct{}),
+		kick:      make(chan struct{}, 1),
+		log:       log,
+	}
+}
+
+type registry struct {
+	lock      sync.RWMutex
+	items     map[string]*Operation
+	retention time.Duration
+
+	// store is nil for the in memory registry, otherwise the
+	// operations changed since the last flush are marked dirty
+	// and written by a single goroutine, in order.
+	store     Store
+	dirty     map[string]struct{}
+	kick      chan struct{}
+	flushLock sync.Mutex
+	log       zerolog.Logger
+}
+
+func (reg *registry) Begin(id string, kind Kind, digest string) (*Operation, bool, error) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	reg.prune()
+
+	if op, ok := reg.items[id]; ok {
+		switch {
+		case op.State == StateInterrupted:
+			// started again to resume it
+		case op.Kind == kind && op.Digest == digest:
+			return op.clone(), true, nil
+		case op.Kind == kind:
+			return nil, false, fmt.Errorf("%w (deploymentId: %s)", ErrPayloadMismatch, id)
+		case !op.State.Finished():
+			return nil, false, fmt.Errorf("%w (deploymentId: %s)", ErrInProgress, id)
+		}
+	}
+
+	op := &Operation{
+		ID:        id,
+		Kind:      kind,
+		Digest:    digest,
+		State:     StatePending,
+		Steps:     []*support.Notification{},
+		CreatedAt: time.Now(),
+	}
+	reg.items[id] = op
+	reg.touch(id)
+
+	return op.clone(), false, nil
+}
+
+func (reg *registry) Discard(id string) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if op, ok := reg.items[id]; ok && op.State == StatePending {
+		delete(reg.items, id)
+		reg.touch(id)
+	}
+}
+
+func (reg *registry) Run(ctx context.Context, id string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reg.update(id, func(op *Operation) {
+		// interrupted while waiting for a worker
+		if op.State == StateInterrupted {
+			cancel()
+			return
+		}
+
+		now := time.Now()
+		op.State = StateRunning
+		op.StartedAt = &now
+		op.cancel = cancel
+		// cancelled while waiting for a worker
+		if op.CancelledAt != nil {
+			cancel()
+		}
+	})
+
+	err := fn(ctx)
+
+	reg.update(id, func(op *Operation) {
+		op.cancel = nil
+		if op.State == StateInterrupted {
+			return
+		}
+
+		now := time.Now()
+		op.FinishedAt = &now
+		switch {
+		case err == nil:
+			op.State = StateSucceeded
+		case op.CancelledAt != nil:
+			op.State = StateCancelled
+			op.Error = err.Error()
+		default:
+			op.State = StateFailed
+			op.Error = err.Error()
+
+			var ce ConflictError
+			if errors.As(err, &ce) {
+				op.Conflict = ce.Conflict()
+			}
+		}
+	})
+
+	return err
+}
+
+func (reg *registry) Cancel(id string, rollback bool) (*Operation, error) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	op, ok := reg.items[id]
+	if !ok {
+		return nil, fmt.Errorf("%w (deploymentId: %s)", ErrNotFound, id)
+	}
+
+	if op.State.Finished() {
+		return nil, fmt.Errorf("%w (deploymentId: %s, state: %s)", ErrFinished, id, op.State)
+	}
+
+	if op.CancelledAt == nil {
+		now := time.Now()
+		op.CancelledAt = &now
+		op.Rollback = rollback
+	}
+
+	if op.cancel != nil {
+		op.cancel()
+	}
+	reg.touch(id)
+
+	return op.clone(), nil
+}
+
+func (reg *registry) Interrupt() []string {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	res := []string{}
+	for id, op := range reg.items {
+		if op.State.Finished() {
+			continue
+		}
+
+		now := time.Now()
+		op.State = StateInterrupted
+		op.Error = "interrupted by service shutdown"
+		op.FinishedAt = &now
+		if op.cancel != nil {
+			op.cancel()
+		}
+		reg.touch(id)
+		res = append(res, id)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func (reg *registry) Rollback(id string) bool {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+
+	op, ok := reg.items[id]
+	return ok && op.CancelledAt != nil && op.Rollback
+}
+
+func (reg *registry) Get(id string) (*Operation, bool) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+
+	op, ok := reg.items[id]
+	if !ok {
+		return nil, false
+	}
+
+	return op.clone(), true
+}
+
+func (reg *registry) Record(e eventbus.Event) {
+	evt, ok := e.(*support.Notification)
+	if !ok || len(evt.TransactionId) == 0 {
+		return
+	}
+
+	reg.update(evt.TransactionId, func(op *Operation) {
+		op.Steps = append(op.Steps, evt)
+		if evt.Resource != nil {
+			op.addResource(*evt.Resource, evt.Reason)
+		}
+	})
+}
+
+func (reg *registry) update(id string, fn func(op *Operation)) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if op, ok := reg.items[id]; ok {
+		fn(op)
+		reg.touch(id)
+	}
+}
+
+// prune removes the finished operations older than the retention window.
+// Must be called holding the lock.
+func (reg *registry) prune() {
+	for id, op := range reg.items {
+		if op.FinishedAt != nil && time.Since(*op.FinishedAt) > reg.retention {
+			delete(reg.items, id)
+			reg.touch(id)
+		}
+	}
+}
+
+// touch marks the operation to be written to the store.
+// Must be called holding the lock.
+func (reg *registry) touch(id string) {
+	if reg.store == nil {
+		return
+	}
+
+	reg.dirty[id] = struct{}{}
+	select {
+	case reg.kick <- struct{}{}:
+	default:
+	}
+}
+
+// persist flushes the changes as they are marked, waiting
+// coalesceDelay so that a burst of them is written at once.
+func (reg *registry) persist() {
+	for range reg.kick {
+		time.Sleep(coalesceDelay)
+		reg.Flush()
+	}
+}
+
+func (reg *registry) Flush() {
+	if reg.store == nil {
+		return
+	}
+
+	reg.flushLock.Lock()
+	defer reg.flushLock.Unlock()
+
+	// snapshot the dirty operations, nil for the removed ones
+	reg.lock.Lock()
+	ops := make(map[string]*Operation, len(reg.dirty))
+	for id := range reg.dirty {
+		if op, ok := reg.items[id]; ok {
+			ops[id] = op.clone()
+		} else {
+			ops[id] = nil
+		}
+	}
+	reg.dirty = make(map[string]struct{})
+	reg.lock.Unlock()
+
+	for id, op := range ops {
+		var err error
+		if op == nil {
+			err = reg.store.Delete(id)
+		} else {
+			err = reg.store.Save(op)
+		}
+		if err == nil {
+			continue
+		}
+
+		reg.log.Error().Err(err).Str("deploymentId", id).Msg("persisting operation")
+
+		// retried on the next change
+		reg.lock.Lock()
+		reg.dirty[id] = struct{}{}
+		reg.lock.Unlock()
+	}
+}
@@ -0,0 +1,202 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/support"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_RunRecordsSteps(t *testing.T) {
+	reg := New(0)
+
+	op, existing, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+	assert.False(t, existing)
+	assert.Equal(t, StatePending, op.State)
+
+	ctx := context.WithValue(context.Background(), middlewares.DeploymentIdKey, "abc")
+	err = reg.Run(ctx, op.ID, func(ctx context.Context) error {
+		reg.Record(support.InfoNotification(ctx, support.ReasonResourceCreated, "created"))
+		reg.Record(support.InfoNotification(ctx, support.ReasonSuccess, "done"))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	got, ok := reg.Get("abc")
+	assert.True(t, ok)
+	assert.Equal(t, StateSucceeded, got.State)
+	assert.Equal(t, 2, len(got.Steps))
+	assert.Equal(t, support.ReasonSuccess, got.Steps[1].Reason)
+	assert.NotNil(t, got.StartedAt)
+	assert.NotNil(t, got.FinishedAt)
+}
+
+func TestRegistry_RunFailure(t *testing.T) {
+	reg := New(0)
+
+	op, _, err := reg.Begin("abc", KindDelete, "d1")
+	assert.Nil(t, err)
+
+	err = reg.Run(context.Background(), op.ID, func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	assert.NotNil(t, err)
+
+	got, _ := reg.Get("abc")
+	assert.Equal(t, StateFailed, got.State)
+	assert.Equal(t, "boom", got.Error)
+}
+
+type testConflict struct{ Manager string }
+
+func (e *testConflict) Error() string         { return "conflict with " + e.Manager }
+func (e *testConflict) Conflict() interface{} { return e }
+
+func TestRegistry_RunConflict(t *testing.T) {
+	reg := New(0)
+
+	op, _, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+
+	err = reg.Run(context.Background(), op.ID, func(ctx context.Context) error {
+		return fmt.Errorf("installing: %w", &testConflict{Manager: "kubectl"})
+	})
+	assert.NotNil(t, err)
+
+	got, _ := reg.Get("abc")
+	assert.Equal(t, StateFailed, got.State)
+	assert.Equal(t, &testConflict{Manager: "kubectl"}, got.Conflict)
+}
+
+func TestRegistry_BeginInProgress(t *testing.T) {
+	reg := New(0)
+
+	_, _, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+
+	_, _, err = reg.Begin("abc", KindDelete, "d1")
+	assert.True(t, errors.Is(err, ErrInProgress))
+}
+
+func TestRegistry_BeginIdempotent(t *testing.T) {
+	reg := New(0)
+
+	op, _, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+
+	got, existing, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+	assert.True(t, existing)
+	assert.Equal(t, op.CreatedAt, got.CreatedAt)
+
+	_, _, err = reg.Begin("abc", KindInstall, "d2")
+	assert.True(t, errors.Is(err, ErrPayloadMismatch))
+
+	reg.Run(context.Background(), op.ID, func(ctx context.Context) error { return nil })
+
+	// still tracked within the retention window
+	got, existing, err = reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+	assert.True(t, existing)
+	assert.Equal(t, StateSucceeded, got.State)
+
+	_, _, err = reg.Begin("abc", KindInstall, "d2")
+	assert.True(t, errors.Is(err, ErrPayloadMismatch))
+
+	// another kind replaces the finished operation
+	_, existing, err = reg.Begin("abc", KindDelete, "d1")
+	assert.Nil(t, err)
+	assert.False(t, existing)
+}
+
+func TestRegistry_Cancel(t *testing.T) {
+	reg := New(0)
+
+	_, err := reg.Cancel("abc", false)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	op, _, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- reg.Run(context.Background(), op.ID, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			assert.True(t, reg.Rollback("abc"))
+			return ctx.Err()
+		})
+	}()
+	<-started
+
+	got, err := reg.Cancel("abc", true)
+	assert.Nil(t, err)
+	assert.NotNil(t, got.CancelledAt)
+	assert.True(t, got.Rollback)
+
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	got, _ = reg.Get("abc")
+	assert.Equal(t, StateCancelled, got.State)
+
+	_, err = reg.Cancel("abc", false)
+	assert.True(t, errors.Is(err, ErrFinished))
+}
+
+func TestRegistry_CancelPending(t *testing.T) {
+	reg := New(0)
+
+	op, _, err := reg.Begin("abc", KindDelete, "d1")
+	assert.Nil(t, err)
+
+	_, err = reg.Cancel("abc", false)
+	assert.Nil(t, err)
+	assert.False(t, reg.Rollback("abc"))
+
+	err = reg.Run(context.Background(), op.ID, func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	got, _ := reg.Get("abc")
+	assert.Equal(t, StateCancelled, got.State)
+}
+
+func TestRegistry_Interrupt(t *testing.T) {
+	reg := New(0)
+
+	running, _, _ := reg.Begin("abc", KindInstall, "d1")
+	pending, _, _ := reg.Begin("def", KindInstall, "d2")
+
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- reg.Run(context.Background(), running.ID, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-started
+
+	assert.Equal(t, []string{"abc", "def"}, reg.Interrupt())
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// picked up by a worker after the interruption
+	err := reg.Run(context.Background(), pending.ID, func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	for _, id := range []string{"abc", "def"} {
+		got, _ := reg.Get(id)
+		assert.Equal(t, StateInterrupted, got.State)
+	}
+	assert.Empty(t, reg.Interrupt())
+}
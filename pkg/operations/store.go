@@ -0,0 +1,99 @@
+package operations
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	StoreMemory    = "memory"
+	StoreFile      = "file"
+	StoreConfigMap = "configmap"
+)
+
+// Store persists the operations tracked by the registry,
+// so that they survive the restarts of the service.
+type Store interface {
+	// Save creates or replaces the operation.
+	Save(op *Operation) error
+	// Delete removes the operation, if any.
+	Delete(id string) error
+	// List returns all the stored operations.
+	List() ([]*Operation, error)
+}
+
+// NewStore returns the store described by spec:
+//
+//   - `memory`                  ' nothing survives a restart
+//   - `file:<directory>`        ' one JSON file per operation, for development
+//   - `configmap:<namespace>`   ' one ConfigMap per operation, for in-cluster use
+func NewStore(spec string, c *rest.Config) (Store, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	case StoreFile:
+		if len(arg) == 0 {
+			return nil, fmt.Errorf("invalid operations store: %s (expected %s:<directory>)", spec, StoreFile)
+		}
+		return NewFileStore(arg)
+	case StoreConfigMap:
+		if len(arg) == 0 {
+			return nil, fmt.Errorf("invalid operations store: %s (expected %s:<namespace>)", spec, StoreConfigMap)
+		}
+
+		cs, err := kubernetes.NewForConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		return NewConfigMapStore(cs, arg), nil
+	default:
+		return nil, fmt.Errorf("unknown operations store: %s (expected one of: %s, %s, %s)",
+			spec, StoreMemory, StoreFile, StoreConfigMap)
+	}
+}
+
+// NewMemoryStore returns a store that keeps the
+// operations only for the lifetime of the process.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		items: make(map[string]*Operation),
+	}
+}
+
+type memoryStore struct {
+	lock  sync.Mutex
+	items map[string]*Operation
+}
+
+func (s *memoryStore) Save(op *Operation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.items[op.ID] = op.clone()
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.items, id)
+	return nil
+}
+
+func (s *memoryStore) List() ([]*Operation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	res := make([]*Operation, 0, len(s.items))
+	for _, op := range s.items {
+		res = append(res, op.clone())
+	}
+	return res, nil
+}
@@ -0,0 +1,134 @@
+package operations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/support"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testStore(t *testing.T, store Store) {
+	op := &Operation{
+		ID:        "b5c1a1e2/with:odd chars",
+		Kind:      KindInstall,
+		Digest:    "d1",
+		State:     StateRunning,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	assert.Nil(t, store.Save(op))
+
+	op.State = StateSucceeded
+	assert.Nil(t, store.Save(op))
+
+	all, err := store.List()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(all)) {
+		assert.Equal(t, op.ID, all[0].ID)
+		assert.Equal(t, StateSucceeded, all[0].State)
+		assert.True(t, op.CreatedAt.Equal(all[0].CreatedAt))
+	}
+
+	assert.Nil(t, store.Delete(op.ID))
+	assert.Nil(t, store.Delete(op.ID))
+
+	all, err = store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(all))
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+
+	testStore(t, store)
+}
+
+func TestConfigMapStore(t *testing.T) {
+	testStore(t, NewConfigMapStore(fake.NewSimpleClientset(), "krateo-system"))
+}
+
+func TestOpenInterruptsRunning(t *testing.T) {
+	store := NewMemoryStore()
+
+	now := time.Now()
+	store.Save(&Operation{ID: "abc", Kind: KindInstall, Digest: "d1", State: StateRunning, CreatedAt: now, StartedAt: &now})
+	store.Save(&Operation{ID: "def", Kind: KindDelete, Digest: "d2", State: StateSucceeded, CreatedAt: now, FinishedAt: &now})
+
+	reg, interrupted, err := Open(store, 0, zerolog.Nop())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc"}, interrupted)
+
+	got, ok := reg.Get("abc")
+	assert.True(t, ok)
+	assert.Equal(t, StateInterrupted, got.State)
+
+	// the store has been updated too
+	all, _ := store.List()
+	for _, op := range all {
+		assert.True(t, op.State.Finished())
+	}
+
+	// sending the request again resumes the operation
+	op, existing, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+	assert.False(t, existing)
+	assert.Equal(t, StatePending, op.State)
+
+	_, existing, _ = reg.Begin("def", KindDelete, "d2")
+	assert.True(t, existing)
+}
+
+// countingStore counts the writes to the underlying store.
+type countingStore struct {
+	Store
+	saves int
+}
+
+func (s *countingStore) Save(op *Operation) error {
+	s.saves++
+	return s.Store.Save(op)
+}
+
+func TestRegistryCoalescesWrites(t *testing.T) {
+	store := &countingStore{Store: NewMemoryStore()}
+	reg := newRegistry(0, store, zerolog.Nop())
+
+	op, _, err := reg.Begin("abc", KindInstall, "d1")
+	assert.Nil(t, err)
+
+	ctx := context.WithValue(context.Background(), middlewares.DeploymentIdKey, "abc")
+	err = reg.Run(ctx, op.ID, func(ctx context.Context) error {
+		// pending and running are written at once
+		reg.Flush()
+		assert.Equal(t, 1, store.saves)
+
+		for i := 0; i < 10; i++ {
+			reg.Record(support.InfoNotification(ctx, support.ReasonApplyingResource, "applying"))
+		}
+		reg.Flush()
+		assert.Equal(t, 2, store.saves)
+
+		// the steps are saved while the operation runs
+		all, _ := store.List()
+		if assert.Equal(t, 1, len(all)) {
+			assert.Equal(t, StateRunning, all[0].State)
+			assert.Equal(t, 10, len(all[0].Steps))
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	reg.Flush()
+	assert.Equal(t, 3, store.saves)
+
+	all, _ := store.List()
+	if assert.Equal(t, 1, len(all)) {
+		assert.Equal(t, StateSucceeded, all[0].State)
+	}
+}
@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// RulePackages is the rule listing the allowed package GroupKinds.
+	RulePackages = "packages"
+	// RuleClaimGroups is the rule listing the allowed claim apiGroup patterns.
+	RuleClaimGroups = "claimGroups"
+	// RuleSupporting is the rule listing the allowed supporting GroupKinds.
+	RuleSupporting = "supporting"
+)
+
+var (
+	DefaultPackages    = []string{"Configuration.pkg.crossplane.io"}
+	DefaultClaimGroups = []string{"krateo.io", "*.krateo.io"}
+	DefaultSupporting  = []string{
+		"Secret",
+		"ConfigMap",
+		"ProviderConfig.helm.crossplane.io",
+		"ProviderConfig.kubernetes.crossplane.io",
+	}
+)
+
+// Policy provides the rules in effect for the `/template` payloads.
+type Policy interface {
+	Rules() *Rules
+}
+
+// Static returns a policy that always provides the same rules.
+func Static(r *Rules) Policy {
+	return &staticPolicy{rules: r}
+}
+
+type staticPolicy struct {
+	rules *Rules
+}
+
+func (p *staticPolicy) Rules() *Rules {
+	return p.rules
+}
+
+// Rules is an allow-list of the objects a `/template` payload can carry.
+type Rules struct {
+	// Packages are the allowed package GroupKinds
+	// (i.e. `Provider.pkg.crossplane.io`).
+	Packages []string `json:"packages"`
+	// ClaimGroups are the allowed claim apiGroup patterns
+	// (i.e. `*.krateo.io`), as in `path.Match`.
+	ClaimGroups []string `json:"claimGroups"`
+	// Supporting are the allowed GroupKinds of the objects shipped
+	// along with the claim (i.e. `ProviderConfig.helm.crossplane.io`,
+	// or `Secret` for the core group).
+	Supporting []string `json:"supporting"`
+}
+
+// NewRules validates the specified allow-lists.
+func NewRules(packages, claimGroups, supporting []string) (*Rules, error) {
+	for _, el := range packages {
+		if gk := schema.ParseGroupKind(el); len(gk.Kind) == 0 || len(gk.Group) == 0 {
+			return nil, fmt.Errorf("invalid package GroupKind: %s", el)
+		}
+	}
+
+	for _, el := range claimGroups {
+		if _, err := path.Match(el, ""); err != nil {
+			return nil, fmt.Errorf("invalid claim apiGroup pattern: %s: %w", el, err)
+		}
+	}
+
+	for _, el := range supporting {
+		if gk := schema.ParseGroupKind(el); len(gk.Kind) == 0 {
+			return nil, fmt.Errorf("invalid supporting GroupKind: %s", el)
+		}
+	}
+
+	return &Rules{Packages: packages, ClaimGroups: claimGroups, Supporting: supporting}, nil
+}
+
+// AllowPackage returns a *DeniedError if the kind is not an allowed package.
+func (r *Rules) AllowPackage(gk schema.GroupKind) error {
+	for _, el := range r.Packages {
+		if el == gk.String() {
+			return nil
+		}
+	}
+
+	return &DeniedError{Rule: RulePackages, Allowed: r.Packages, GroupKind: gk}
+}
+
+// AllowClaim returns a *DeniedError if the kind group matches none of the claim patterns.
+func (r *Rules) AllowClaim(gk schema.GroupKind) error {
+	for _, el := range r.ClaimGroups {
+		if ok, _ := path.Match(el, gk.Group); ok {
+			return nil
+		}
+	}
+
+	return &DeniedError{Rule: RuleClaimGroups, Allowed: r.ClaimGroups, GroupKind: gk}
+}
+
+// AllowSupporting returns a *DeniedError if the kind is not an allowed supporting object.
+func (r *Rules) AllowSupporting(gk schema.GroupKind) error {
+	for _, el := range r.Supporting {
+		if el == gk.String() {
+			return nil
+		}
+	}
+
+	return &DeniedError{Rule: RuleSupporting, Allowed: r.Supporting, GroupKind: gk}
+}
+
+// DeniedError reports the rule that denied an object.
+type DeniedError struct {
+	Rule      string
+	Allowed   []string
+	GroupKind schema.GroupKind
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("kind: %s in apiGroup: %s is not allowed by rule: %s (allowed: %s)",
+		e.GroupKind.Kind, e.GroupKind.Group, e.Rule, strings.Join(e.Allowed, ", "))
+}
+
+// SplitList splits a comma or newline separated list, dropping the empty items.
+func SplitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+}
@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRules(t *testing.T) {
+	rules, err := NewRules(
+		[]string{"Configuration.pkg.crossplane.io", "Provider.pkg.crossplane.io"},
+		[]string{"*.krateo.io", "*.acme.internal"},
+		[]string{"Secret", "ProviderConfig.helm.crossplane.io"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Nil(t, rules.AllowPackage(schema.GroupKind{Group: "pkg.crossplane.io", Kind: "Provider"}))
+	assert.Nil(t, rules.AllowClaim(schema.GroupKind{Group: "modules.krateo.io", Kind: "Core"}))
+	assert.Nil(t, rules.AllowClaim(schema.GroupKind{Group: "db.acme.internal", Kind: "Postgres"}))
+
+	err = rules.AllowPackage(schema.GroupKind{Group: "pkg.crossplane.io", Kind: "Function"})
+	var de *DeniedError
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, RulePackages, de.Rule)
+
+	err = rules.AllowClaim(schema.GroupKind{Group: "acme.internal", Kind: "Postgres"})
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, RuleClaimGroups, de.Rule)
+
+	assert.Nil(t, rules.AllowSupporting(schema.GroupKind{Kind: "Secret"}))
+	assert.Nil(t, rules.AllowSupporting(schema.GroupKind{Group: "helm.crossplane.io", Kind: "ProviderConfig"}))
+
+	err = rules.AllowSupporting(schema.GroupKind{Group: "aws.crossplane.io", Kind: "ProviderConfig"})
+	assert.True(t, errors.As(err, &de))
+	assert.Equal(t, RuleSupporting, de.Rule)
+
+	_, err = NewRules([]string{"Configuration"}, nil, nil)
+	assert.NotNil(t, err)
+
+	_, err = NewRules(nil, []string{"[.krateo.io"}, nil)
+	assert.NotNil(t, err)
+
+	_, err = NewRules(nil, nil, []string{".helm.crossplane.io"})
+	assert.NotNil(t, err)
+
+	// the defaults accept the claim groups the baseline did
+	rules, err = NewRules(DefaultPackages, DefaultClaimGroups, DefaultSupporting)
+	if assert.Nil(t, err) {
+		assert.Nil(t, rules.AllowClaim(schema.GroupKind{Group: "krateo.io", Kind: "Core"}))
+		assert.Nil(t, rules.AllowClaim(schema.GroupKind{Group: "modules.krateo.io", Kind: "Core"}))
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	cs := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-bridge-policy", Namespace: "krateo-system"},
+		Data: map[string]string{
+			KeyClaimGroups: "*.acme.internal",
+		},
+	})
+
+	defaults := &Rules{Packages: DefaultPackages, ClaimGroups: DefaultClaimGroups, Supporting: DefaultSupporting}
+	w := newWatcher(cs, "krateo-system", "kube-bridge-policy", defaults, zerolog.Nop())
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	w.Start(stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := w.WaitForSync(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"*.acme.internal"}, w.Rules().ClaimGroups)
+	assert.Equal(t, DefaultPackages, w.Rules().Packages)
+
+	err = cs.CoreV1().ConfigMaps("krateo-system").Delete(ctx, "kube-bridge-policy", metav1.DeleteOptions{})
+	assert.Nil(t, err)
+
+	err = wait.PollImmediateUntilWithContext(ctx, 10*time.Millisecond, func(context.Context) (bool, error) {
+		return len(w.Rules().ClaimGroups) == len(DefaultClaimGroups), nil
+	})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// KeyPackages is the ConfigMap key holding the RulePackages list.
+	KeyPackages = "packages"
+	// KeyClaimGroups is the ConfigMap key holding the RuleClaimGroups list.
+	KeyClaimGroups = "claimGroups"
+	// KeySupporting is the ConfigMap key holding the RuleSupporting list.
+	KeySupporting = "supporting"
+)
+
+// Watcher is a Policy loaded from a ConfigMap and kept in sync with it.
+//
+// Each key missing from the ConfigMap (or the whole ConfigMap) falls back
+// to the default rules, invalid data keeps the rules previously in effect.
+type Watcher interface {
+	Policy
+	// Start runs the underlying informer until stopCh is closed.
+	Start(stopCh <-chan struct{})
+	// WaitForSync blocks until the ConfigMap has been loaded (or found
+	// missing), or the context is done: until then the defaults are served.
+	WaitForSync(ctx context.Context) error
+}
+
+func NewWatcher(c *rest.Config, namespace, name string, defaults *Rules, log zerolog.Logger) (Watcher, error) {
+	cs, err := kubernetes.NewForConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWatcher(cs, namespace, name, defaults, log), nil
+}
+
+func newWatcher(cs kubernetes.Interface, namespace, name string, defaults *Rules, log zerolog.Logger) *watcherImpl {
+	factory := informers.NewSharedInformerFactoryWithOptions(cs, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+
+	res := &watcherImpl{
+		informer: factory.Core().V1().ConfigMaps().Informer(),
+		defaults: defaults,
+		rules:    defaults,
+		log:      log.With().Str("configmap", fmt.Sprintf("%s/%s", namespace, name)).Logger(),
+	}
+
+	res.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { res.load(obj) },
+		UpdateFunc: func(_, obj interface{}) { res.load(obj) },
+		DeleteFunc: func(_ interface{}) { res.set(res.defaults) },
+	})
+
+	return res
+}
+
+type watcherImpl struct {
+	informer cache.SharedIndexInformer
+	defaults *Rules
+	log      zerolog.Logger
+
+	lock  sync.RWMutex
+	rules *Rules
+}
+
+func (impl *watcherImpl) Start(stopCh <-chan struct{}) {
+	go impl.informer.Run(stopCh)
+}
+
+func (impl *watcherImpl) WaitForSync(ctx context.Context) error {
+	if !cache.WaitForCacheSync(ctx.Done(), impl.informer.HasSynced) {
+		return fmt.Errorf("waiting for the policy configmap: %w", ctx.Err())
+	}
+
+	// the event handlers may not have run yet
+	for _, el := range impl.informer.GetStore().List() {
+		impl.load(el)
+	}
+	return nil
+}
+
+func (impl *watcherImpl) Rules() *Rules {
+	impl.lock.RLock()
+	defer impl.lock.RUnlock()
+
+	return impl.rules
+}
+
+func (impl *watcherImpl) load(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+
+	packages, claimGroups, supporting := impl.defaults.Packages, impl.defaults.ClaimGroups, impl.defaults.Supporting
+	if v, ok := cm.Data[KeyPackages]; ok {
+		packages = SplitList(v)
+	}
+	if v, ok := cm.Data[KeyClaimGroups]; ok {
+		claimGroups = SplitList(v)
+	}
+	if v, ok := cm.Data[KeySupporting]; ok {
+		supporting = SplitList(v)
+	}
+
+	rules, err := NewRules(packages, claimGroups, supporting)
+	if err != nil {
+		impl.log.Error().Err(err).Msg("invalid policy, keeping the previous rules")
+		return
+	}
+
+	impl.set(rules)
+}
+
+func (impl *watcherImpl) set(rules *Rules) {
+	impl.lock.Lock()
+	impl.rules = rules
+	impl.lock.Unlock()
+
+	impl.log.Info().
+		Strs(RulePackages, rules.Packages).
+		Strs(RuleClaimGroups, rules.ClaimGroups).
+		Strs(RuleSupporting, rules.Supporting).
+		Msg("policy loaded")
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -48,3 +49,16 @@ func EnvBool(key string, defaultValue bool) bool {
 	}
 	return res
 }
+
+func EnvDuration(key string, defaultValue time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	res, err := time.ParseDuration(strings.TrimSpace(val))
+	if err != nil {
+		return defaultValue
+	}
+	return res
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/krateoplatformops/kube-bridge/pkg/eventbus"
 	"github.com/krateoplatformops/kube-bridge/pkg/middlewares"
+	"github.com/krateoplatformops/kube-bridge/pkg/workers"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 const (
@@ -18,12 +20,19 @@ const (
 )
 
 const (
-	ReasonWaitForResource = "WaitForResource"
-	ReasonSuccess         = "Success"
-	ReasonFailure         = "Failure"
-	ReasonResourceUpdated = "ResourceUpdated"
-	ReasonResourceCreated = "ResourceCreated"
-	ReasonPing            = "Ping"
+	ReasonWaitForResource  = "WaitForResource"
+	ReasonSuccess          = "Success"
+	ReasonFailure          = "Failure"
+	ReasonResourceUpdated  = "ResourceUpdated"
+	ReasonResourceCreated  = "ResourceCreated"
+	ReasonResourceDeleted  = "ResourceDeleted"
+	ReasonApplyingResource = "ApplyingResource"
+	ReasonDeletingResource = "DeletingResource"
+	ReasonConditionChanged = "ConditionChanged"
+	ReasonRevisionChanged  = "RevisionChanged"
+	ReasonQueued           = "Queued"
+	ReasonCancelled        = "Cancelled"
+	ReasonPing             = "Ping"
 )
 
 func InfoNotification(ctx context.Context, rsn, msg string) *Notification {
@@ -61,27 +70,50 @@ func ErrorNotification(ctx context.Context, rsn string, err error) *Notification
 }
 
 type Notification struct {
-	Level         string `json:"level"`
-	Time          int64  `json:"time"`
-	Message       string `json:"message"`
-	Source        string `json:"source"`
-	Reason        string `json:"reason"`
-	TransactionId string `json:"deploymentId"`
+	Level         string       `json:"level"`
+	Time          int64        `json:"time"`
+	Message       string       `json:"message"`
+	Source        string       `json:"source"`
+	Reason        string       `json:"reason"`
+	TransactionId string       `json:"deploymentId"`
+	Resource      *ResourceRef `json:"resource,omitempty"`
+}
+
+// ResourceRef identifies the Kubernetes object a notification refers to.
+type ResourceRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace,omitempty"`
+}
+
+// WithResource sets the object the notification refers to.
+func (e *Notification) WithResource(obj *unstructured.Unstructured) *Notification {
+	e.Resource = &ResourceRef{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Name:       obj.GetName(),
+		Namespace:  obj.GetNamespace(),
+	}
+	return e
 }
 
 func (e *Notification) EventID() eventbus.EventID {
 	return NotificationEventID
 }
 
-func NotificationDispatcher(addr string) eventbus.EventHandler {
+// NotificationDispatcher posts the notifications to the logger service
+// using the workers pool; when the pool queue is full the notification
+// is dropped, so that a slow logger service cannot pile up goroutines.
+func NotificationDispatcher(addr string, pool workers.Pool) eventbus.EventHandler {
 	return func(e eventbus.Event) {
 		if e.EventID() != NotificationEventID {
 			return
 		}
 
-		go func() {
-			evt := e.(*Notification)
+		evt := e.(*Notification)
 
+		err := pool.Submit(func() {
 			dat, err := json.Marshal(evt)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "reqId: %s - error: %s", evt.TransactionId, err.Error())
@@ -104,6 +136,9 @@ func NotificationDispatcher(addr string) eventbus.EventHandler {
 				fmt.Fprintf(os.Stderr, "reqId: %s - error: %s", evt.TransactionId, err.Error())
 				return
 			}
-		}()
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reqId: %s - notification dropped: %s", evt.TransactionId, err.Error())
+		}
 	}
 }
@@ -0,0 +1,200 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrQueueFull is returned when a task is submitted to a pool
+	// whose workers are all busy and whose queue is full.
+	ErrQueueFull = errors.New("queue is full")
+	// ErrStopped is returned when a task is submitted to a stopped pool.
+	ErrStopped = errors.New("workers pool is stopped")
+)
+
+// Stats is a snapshot of the pool load.
+type Stats struct {
+	Workers int `json:"workers"`
+	Active  int `json:"active"`
+	Queued  int `json:"queued"`
+	// Reserved are the queue slots held by the tasks
+	// not submitted yet (i.e. waiting for a lock).
+	Reserved  int `json:"reserved"`
+	QueueSize int `json:"queueSize"`
+}
+
+// Pool runs the submitted tasks with a bounded number of workers,
+// holding up to a bounded number of tasks waiting for a free worker.
+type Pool interface {
+	// Submit enqueues the task without blocking,
+	// returning ErrQueueFull when there is no room.
+	Submit(task func()) error
+	// Reserve holds a queue slot for a task submitted later (i.e. once
+	// a lock is acquired), returning ErrQueueFull when there is no room.
+	Reserve() (*Reservation, error)
+	// Stats returns the current load of the pool.
+	Stats() Stats
+	// Stop rejects the new tasks and waits for the queued and running
+	// ones to complete, or for the context to be done.
+	Stop(ctx context.Context) error
+}
+
+// New starts a pool with the specified number of workers and queue size.
+func New(workers, queueSize int) Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	res := &pool{
+		workers: workers,
+		tasks:   make(chan func(), queueSize),
+	}
+
+	res.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go res.work()
+	}
+
+	return res
+}
+
+// Reservation is a queue slot held for a task not submitted yet.
+type Reservation struct {
+	p    *pool
+	once sync.Once
+}
+
+// Submit enqueues the task in the reserved slot, returning
+// ErrStopped when the pool has been stopped meanwhile.
+func (r *Reservation) Submit(task func()) error {
+	err := ErrStopped
+	r.once.Do(func() {
+		r.p.lock.Lock()
+		defer r.p.lock.Unlock()
+
+		r.p.reserved--
+		if r.p.stopped {
+			return
+		}
+
+		// the slot has been kept free by Submit
+		select {
+		case r.p.tasks <- task:
+			err = nil
+		default:
+			err = ErrQueueFull
+		}
+	})
+	return err
+}
+
+// Cancel frees the reserved slot, unless already submitted.
+func (r *Reservation) Cancel() {
+	r.once.Do(func() {
+		r.p.lock.Lock()
+		r.p.reserved--
+		r.p.lock.Unlock()
+	})
+}
+
+type pool struct {
+	workers int
+	tasks   chan func()
+	active  int32
+	wg      sync.WaitGroup
+	// lock guards stopped, so that no task is sent on the channel
+	// once it is closed, and reserved, so that the queued and
+	// the reserved tasks never exceed the queue size.
+	lock     sync.Mutex
+	stopped  bool
+	reserved int
+}
+
+func (p *pool) Submit(task func()) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stopped {
+		return ErrStopped
+	}
+
+	// the reserved slots are not available
+	if p.reserved > 0 && len(p.tasks)+p.reserved >= cap(p.tasks) {
+		return ErrQueueFull
+	}
+
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *pool) Reserve() (*Reservation, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stopped {
+		return nil, ErrStopped
+	}
+
+	if len(p.tasks)+p.reserved >= cap(p.tasks) {
+		return nil, ErrQueueFull
+	}
+
+	p.reserved++
+	return &Reservation{p: p}, nil
+}
+
+func (p *pool) Stats() Stats {
+	p.lock.Lock()
+	reserved := p.reserved
+	p.lock.Unlock()
+
+	return Stats{
+		Workers:   p.workers,
+		Active:    int(atomic.LoadInt32(&p.active)),
+		Queued:    len(p.tasks),
+		Reserved:  reserved,
+		QueueSize: cap(p.tasks),
+	}
+}
+
+func (p *pool) Stop(ctx context.Context) error {
+	p.lock.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.tasks)
+	}
+	p.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *pool) work() {
+	defer p.wg.Done()
+
+	for task := range p.tasks {
+		atomic.AddInt32(&p.active, 1)
+		task()
+		atomic.AddInt32(&p.active, -1)
+	}
+}
@@ -0,0 +1,104 @@
+package workers
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	p := New(2, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		err := p.Submit(func() {
+			started <- struct{}{}
+			<-release
+		})
+		assert.Nil(t, err)
+		// wait for a worker to pick it up before the next one
+		<-started
+	}
+
+	done := make(chan struct{})
+	assert.Nil(t, p.Submit(func() { close(done) }))
+	assert.ErrorIs(t, p.Submit(func() {}), ErrQueueFull)
+
+	assert.Equal(t, Stats{Workers: 2, Active: 2, Queued: 1, QueueSize: 1}, p.Stats())
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queued task not run")
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	p := New(1, 2)
+
+	release := make(chan struct{})
+	var ran int32
+	for i := 0; i < 3; i++ {
+		err := p.Submit(func() {
+			<-release
+			atomic.AddInt32(&ran, 1)
+		})
+		assert.Nil(t, err)
+		if i == 0 {
+			// let the worker pick up the first task
+			for p.Stats().Active == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Stop(ctx), context.DeadlineExceeded)
+	assert.ErrorIs(t, p.Submit(func() {}), ErrStopped)
+
+	// the queued tasks are flushed
+	close(release)
+	assert.Nil(t, p.Stop(context.Background()))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&ran))
+}
+
+func TestPoolReserve(t *testing.T) {
+	p := New(1, 2)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	assert.Nil(t, p.Submit(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	r1, err := p.Reserve()
+	assert.Nil(t, err)
+	r2, err := p.Reserve()
+	assert.Nil(t, err)
+
+	// the reserved slots count against the queue size
+	_, err = p.Reserve()
+	assert.ErrorIs(t, err, ErrQueueFull)
+	assert.ErrorIs(t, p.Submit(func() {}), ErrQueueFull)
+	assert.Equal(t, Stats{Workers: 1, Active: 1, Reserved: 2, QueueSize: 2}, p.Stats())
+
+	done := make(chan struct{})
+	assert.Nil(t, r1.Submit(func() { close(done) }))
+	r2.Cancel()
+	assert.Equal(t, Stats{Workers: 1, Active: 1, Queued: 1, QueueSize: 2}, p.Stats())
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reserved task not run")
+	}
+}